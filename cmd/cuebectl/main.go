@@ -26,6 +26,9 @@ func main() {
 	}
 	globalflag.AddGlobalFlags(root.PersistentFlags(), commandName())
 	root.AddCommand(cmd.NewCmdApply(commandName(), flags, streams))
+	root.AddCommand(cmd.NewCmdImport(commandName(), flags, streams))
+	root.AddCommand(cmd.NewCmdController(commandName(), flags, streams))
+	root.AddCommand(cmd.NewCmdTest(commandName(), streams))
 
 	if err := root.Execute(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,156 @@
+// Code generated by cuebectl import schemas. DO NOT EDIT.
+
+package v1
+
+#Binding: {
+	apiVersion: "v1"
+	kind:       "Binding"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#ComponentStatus: {
+	apiVersion: "v1"
+	kind:       "ComponentStatus"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#ConfigMap: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Endpoints: {
+	apiVersion: "v1"
+	kind:       "Endpoints"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Event: {
+	apiVersion: "v1"
+	kind:       "Event"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#LimitRange: {
+	apiVersion: "v1"
+	kind:       "LimitRange"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Namespace: {
+	apiVersion: "v1"
+	kind:       "Namespace"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Node: {
+	apiVersion: "v1"
+	kind:       "Node"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#PersistentVolume: {
+	apiVersion: "v1"
+	kind:       "PersistentVolume"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#PersistentVolumeClaim: {
+	apiVersion: "v1"
+	kind:       "PersistentVolumeClaim"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Pod: {
+	apiVersion: "v1"
+	kind:       "Pod"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#PodTemplate: {
+	apiVersion: "v1"
+	kind:       "PodTemplate"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#ReplicationController: {
+	apiVersion: "v1"
+	kind:       "ReplicationController"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#ResourceQuota: {
+	apiVersion: "v1"
+	kind:       "ResourceQuota"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Secret: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#Service: {
+	apiVersion: "v1"
+	kind:       "Service"
+	metadata?: {
+		...
+	}
+	...
+}
+
+#ServiceAccount: {
+	apiVersion: "v1"
+	kind:       "ServiceAccount"
+	metadata?: {
+		...
+	}
+	...
+}
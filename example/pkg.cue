@@ -3,41 +3,35 @@ package test
 import (
     corev1 "k8s.io/api/core/v1"
     rbacv1 "k8s.io/api/rbac/v1"
+    rbac "rbac.authorization.k8s.io/v1"
 )
 
-// imported schemas don't specify kind/apiversion
-#Namespace: corev1.#Namespace & {
-    apiVersion: "v1"
-    kind: "Namespace"
-}
-
-TestNs: #Namespace & {
-    apiVersion: "v1"
-    kind: "Namespace"
+// definitions generated by `cuebectl import schemas` fix apiVersion and kind. The core group's are in the same
+// package as the types from `cue get go`; other groups' are unified with them.
+TestNs: corev1.#Namespace & {
     metadata: generateName: "test-ns-"
 }
 
+// read from the cluster, but never written
+DefaultNs: corev1.#Namespace & {
+    metadata: name: "default"
+} @cuebectl(ref)
+
 NoGenNameServiceAccount: corev1.#ServiceAccount & {
-    apiVersion: "v1"
-    kind: "ServiceAccount"
     metadata: {
         name: "test"
-        namespace: "default"
+        namespace: DefaultNs.metadata.name
     }
 }
 
 TestServiceAccount: corev1.#ServiceAccount & {
-    apiVersion: "v1"
-    kind: "ServiceAccount"
     metadata: {
         generateName: "test-sa-"
         namespace: TestNs.metadata.name
     }
 }
 
-TestClusterRoleBinding: rbacv1.#ClusterRoleBinding & {
-    apiVersion: "rbac.authorization.k8s.io/v1"
-    kind: "ClusterRoleBinding"
+TestClusterRoleBinding: rbac.#ClusterRoleBinding & rbacv1.#ClusterRoleBinding & {
     metadata: generateName: "test-"
     roleRef: {
         apiGroup: "rbac.authorization.k8s.io"
@@ -53,9 +47,7 @@ TestClusterRoleBinding: rbacv1.#ClusterRoleBinding & {
     ]
 }
 
-TestClusterRole: rbacv1.#ClusterRole & {
-    apiVersion: "rbac.authorization.k8s.io/v1"
-    kind: "ClusterRole"
+TestClusterRole: rbac.#ClusterRole & rbacv1.#ClusterRole & {
     metadata: generateName: "test-"
     rules: [
         {
@@ -70,9 +62,7 @@ TestClusterRole: rbacv1.#ClusterRole & {
     ]
 }
 
-DependentClusterRoleBinding: rbacv1.#ClusterRoleBinding & {
-    apiVersion: "rbac.authorization.k8s.io/v1"
-    kind: "ClusterRoleBinding"
+DependentClusterRoleBinding: rbac.#ClusterRoleBinding & rbacv1.#ClusterRoleBinding & {
     metadata: generateName: "test-"
     roleRef: {
         apiGroup: "rbac.authorization.k8s.io"
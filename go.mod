@@ -5,13 +5,20 @@ go 1.15
 require (
 	cuelang.org/go v0.3.0-alpha6
 	github.com/davecgh/go-spew v1.1.1
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/go-logr/logr v0.2.0
 	github.com/google/addlicense v0.0.0-20200906110928-a0294312aa76
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.5
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	k8s.io/api v0.19.2
 	k8s.io/apimachinery v0.19.2
 	k8s.io/cli-runtime v0.19.2
 	k8s.io/client-go v0.19.2
 	k8s.io/component-base v0.19.2
 	k8s.io/klog/v2 v2.2.0
 	k8s.io/kubectl v0.19.2
+	sigs.k8s.io/yaml v1.2.0
 )
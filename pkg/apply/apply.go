@@ -7,76 +7,78 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"strings"
+	"path/filepath"
 
-	"cuelang.org/go/cue/load"
 	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/load"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/dynamic"
 
-	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/cuelock"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/facts"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
 )
 
-func CueDir(ctx context.Context, out io.Writer, client dynamic.Interface, mapper meta.RESTMapper, path string, watch bool) (*controller.ClusterState, error) {
+// CueDir loads the cue instance in path and applies it. If cluster is not nil, its facts are filled into the instance
+// before it is unified with the cluster state.
+func CueDir(ctx context.Context, out io.Writer, client dynamic.Interface, mapper meta.RESTMapper, path string, watch bool, options reconcile.Options, cluster *facts.Cluster) (*reconcile.Result, error) {
+	r, b, instance, err := Load(path, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if options.Name == "" {
+		options.Name = DefaultName(b)
+	}
+	return CueInstance(ctx, out, client, mapper, r, instance, watch, options)
+}
+
+// Load builds the single cue instance in path. If cluster is not nil, its facts are filled into the instance.
+func Load(path string, cluster *facts.Cluster) (*cue.Runtime, *build.Instance, *cue.Instance, error) {
 	is := load.Instances([]string{"."}, &load.Config{
 		Dir: path,
 	})
 	if len(is) > 1 {
-		return nil, fmt.Errorf("multiple instance loading currently not supported")
+		return nil, nil, nil, fmt.Errorf("multiple instance loading currently not supported")
 	}
 	if len(is) < 1 {
-		return nil, fmt.Errorf("no instances found")
+		return nil, nil, nil, fmt.Errorf("no instances found")
 	}
-	r := cue.Runtime{}
+	cuelock.Lock()
+	defer cuelock.Unlock()
+	r := &cue.Runtime{}
 	instance, err := r.Build(is[0])
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return CueInstance(ctx, out, client, mapper, &r, instance, watch)
+	if cluster != nil {
+		if instance, err = facts.Fill(r, is[0], instance, cluster); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return r, is[0], instance, nil
 }
 
-func CueInstance(ctx context.Context, out io.Writer, client dynamic.Interface, mapper meta.RESTMapper, runtime *cue.Runtime, instance *cue.Instance, watch bool) (*controller.ClusterState, error) {
-	cueInstanceController := controller.NewCueInstanceController(client, mapper, runtime, instance)
-	stateChan := make(chan controller.ClusterState)
-	errChan := make(chan error)
+// DefaultName names an instance after its package, or the directory it was loaded from if it has no package name
+func DefaultName(instance *build.Instance) string {
+	if instance.PkgName != "" && instance.PkgName != "_" {
+		return ensure.InstanceName(instance.PkgName)
+	}
+	return ensure.InstanceName(filepath.Base(instance.Dir))
+}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	count, err := cueInstanceController.Start(ctx, stateChan, errChan)
+// CueInstance applies instance, printing progress to out. Unless watch is set, it returns once every label of the
+// instance has been applied.
+func CueInstance(ctx context.Context, out io.Writer, client dynamic.Interface, mapper meta.RESTMapper, runtime *cue.Runtime, instance *cue.Instance, watch bool, options reconcile.Options) (*reconcile.Result, error) {
+	if watch {
+		options.Termination = reconcile.Continuous
+	}
+	options.Subscribers = append(options.Subscribers, NewPrinter(out))
+	r, err := reconcile.NewReconciler(client, mapper, runtime, instance, options)
 	if err != nil {
 		return nil, err
 	}
-
-	var lastState *controller.ClusterState
-	printed := map[string]struct{}{}
-	for {
-		select {
-		case current := <-stateChan:
-			lastState = &current
-			if !watch && count == len(current) {
-				cancel()
-			}
-			for l, u := range current {
-				path := strings.Join(l.Path, "/")
-				if _, ok := printed[path]; ok {
-					continue
-				}
-				printed[path] = struct{}{}
-				if _, err := fmt.Fprintf(out,
-					"created %s: %s/%s (%s)\n",
-					path, u.GetNamespace(), u.GetName(), u.GroupVersionKind()); err != nil {
-					return nil, err
-				}
-			}
-		case err := <-errChan:
-			if _, err := fmt.Fprintln(out, err); err != nil {
-				return nil, err
-			}
-		case <-ctx.Done():
-			return lastState, nil
-		default:
-		}
-	}
+	return r.Run(ctx)
 }
-
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package apply
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+)
+
+// Printer is a Subscriber that prints each object the first time it appears in the cluster state, errors and
+// warnings.
+type Printer struct {
+	out     io.Writer
+	printed map[string]struct{}
+}
+
+var _ controller.Subscriber = &Printer{}
+
+// NewPrinter returns a Printer that writes to out
+func NewPrinter(out io.Writer) *Printer {
+	return &Printer{
+		out:     out,
+		printed: map[string]struct{}{},
+	}
+}
+
+func (p *Printer) Handle(e controller.Event) error {
+	switch e := e.(type) {
+	case controller.StateEvent:
+		return p.printState(e.State)
+	case controller.ErrorEvent:
+		_, err := fmt.Fprintln(p.out, e.Err)
+		return err
+	case controller.WarningEvent:
+		_, err := fmt.Fprintf(p.out, "%s: %s\n", e.Label, e.Message)
+		return err
+	}
+	return nil
+}
+
+func (p *Printer) printState(state controller.ClusterState) error {
+	for l, u := range state {
+		path := strings.Join(l.Path, "/")
+		if _, ok := p.printed[path]; ok {
+			continue
+		}
+		p.printed[path] = struct{}{}
+		if l.List {
+			if _, err := fmt.Fprintf(p.out,
+				"listed %s: %d %s\n",
+				path, len(u.Object["items"].([]interface{})), l.Resource); err != nil {
+				return err
+			}
+			continue
+		}
+		action := "created"
+		if l.ReadOnly {
+			action = "referenced"
+		}
+		if _, err := fmt.Fprintf(p.out,
+			"%s %s: %s/%s (%s)\n",
+			action, path, u.GetNamespace(), u.GetName(), u.GroupVersionKind()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
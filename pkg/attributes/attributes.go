@@ -0,0 +1,109 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package attributes
+
+import (
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// Key is the name of the cue attribute read by cuebectl, i.e. @cuebectl(...)
+const Key = "cuebectl"
+
+const (
+	// Adopt allows an existing object that isn't managed by cuebectl to be bound to the field
+	Adopt = "adopt"
+
+	// Force allows an object managed by another instance to be adopted
+	Force = "force"
+
+	// Selector finds an existing object by label: the object to adopt for fields that use generateName, or the
+	// object to read for references
+	Selector = "selector"
+
+	// Ref marks a field as a read-only reference to an existing object. The object is filled into the instance, but
+	// is never written.
+	Ref = "ref"
+
+	// List marks a field as a read-only list of existing objects. The objects of the field's apiVersion and kind
+	// that match the selector (and metadata.namespace, if set) are filled into the field's items.
+	List = "list"
+
+	// Context is the name of the kube context of the cluster that the field's object is in, i.e. context="spoke-1".
+	// Fields without a context are in the default cluster.
+	Context = "context"
+
+	// ServiceAccount is a service account to impersonate when writing the field's object, i.e.
+	// serviceAccount="tenant" or serviceAccount="tenant-a/deployer". The namespace defaults to the object's.
+	ServiceAccount = "serviceAccount"
+
+	// Sensitive marks a field, or a field within an object, whose values are masked in output, like the payload of a
+	// Secret
+	Sensitive = "sensitive"
+)
+
+// Attributes are the entries of a @cuebectl(...) attribute. Flags (entries without a value) map to the empty string.
+type Attributes map[string]string
+
+// Parse reads the @cuebectl attribute of a value. A value without the attribute has no entries.
+func Parse(v cue.Value) Attributes {
+	attrs := Attributes{}
+	a := v.Attribute(Key)
+	if a.Err() != nil {
+		// the attribute is not present
+		return attrs
+	}
+	for i := 0; ; i++ {
+		entry, err := a.String(i)
+		if err != nil {
+			break
+		}
+		key, value := entry, ""
+		if eq := strings.Index(entry, "="); eq >= 0 {
+			key, value = entry[:eq], strings.TrimSpace(entry[eq+1:])
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// Contexts returns the kube contexts named by the top-level fields of v, in order
+func Contexts(v cue.Value) ([]string, error) {
+	fields, err := v.Fields()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	var contexts []string
+	for fields.Next() {
+		context, ok := Parse(fields.Value()).Get(Context)
+		if _, dup := seen[context]; !ok || context == "" || dup {
+			continue
+		}
+		seen[context] = struct{}{}
+		contexts = append(contexts, context)
+	}
+	return contexts, nil
+}
+
+// Flag returns true if the entry is present
+func (a Attributes) Flag(key string) bool {
+	_, ok := a[key]
+	return ok
+}
+
+// Get returns the value of a key=value entry
+func (a Attributes) Get(key string) (string, bool) {
+	v, ok := a[key]
+	return v, ok
+}
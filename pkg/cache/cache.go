@@ -4,47 +4,207 @@
 package cache
 
 import (
+	"sort"
 	"strings"
 	"sync"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	"github.com/cuebernetes/cuebectl/pkg/identity"
+	"github.com/cuebernetes/cuebectl/pkg/metrics"
 )
 
 var _ Interface = &DynamicInformerCache{}
 
+// DynamicInformerCache runs informers scoped to the objects identified by locators. Informers are shared by all
+// locators with the same scope, and stopped when no locator uses them.
 type DynamicInformerCache struct {
 	client dynamic.Interface
 
-	// the keys are write-once, so a sync.Map will work fine and reduce lock contention
-	informers sync.Map
+	// managedSelector selects the objects managed by the instance, which share an informer per NGVR
+	managedSelector string
+
+	// queue receives the objects identified by watched locators when they change
+	queue workqueue.Interface
+
+	informers map[Scope]*scopedInformer
+	// scopes records the scope watched for each path
+	scopes map[string]Scope
+	sync.RWMutex
+}
+
+type scopedInformer struct {
+	informers.GenericInformer
+	stop chan struct{}
+
+	// locators that use the informer, keyed by path. informers have a single event handler that dispatches events
+	// to the locators, since handlers can't be removed from an informer.
+	locators map[string]identity.Locator
+	sync.RWMutex
+}
+
+// dispatch adds obj to queue once for each locator that identifies it
+func (s *scopedInformer) dispatch(queue workqueue.Interface, obj interface{}) {
+	u, ok := identity.ToUnstructured(obj)
+	if !ok {
+		return
+	}
+	s.RLock()
+	defer s.RUnlock()
+	for _, l := range s.locators {
+		if l.Matches(u) {
+			queue.Add(&identity.LocatedUnstructured{Locator: l, Unstructured: u})
+		}
+	}
 }
 
-func NewDynamicInformerCache(client dynamic.Interface) *DynamicInformerCache {
+// replay queues the objects in the informer's store that are identified by locator, since the informer won't send
+// events for them until they change
+func (s *scopedInformer) replay(queue workqueue.Interface, locator identity.Locator) {
+	for _, obj := range s.Informer().GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if ok && locator.Matches(u) {
+			queue.Add(&identity.LocatedUnstructured{Locator: locator, Unstructured: u})
+		}
+	}
+}
+
+// NewDynamicInformerCache returns a cache that watches managed objects with managedSelector, the label selector
+// for objects owned by the instance. Changes to watched objects are added to queue.
+func NewDynamicInformerCache(client dynamic.Interface, managedSelector string, queue workqueue.Interface) *DynamicInformerCache {
 	return &DynamicInformerCache{
-		client:    client,
-		informers: sync.Map{},
+		client:          client,
+		managedSelector: managedSelector,
+		queue:           queue,
+		informers:       map[Scope]*scopedInformer{},
+		scopes:          map[string]Scope{},
+	}
+}
+
+// Scope returns the scope of the informer that watches the objects identified by locator
+func (d *DynamicInformerCache) Scope(locator *identity.Locator) Scope {
+	scope := Scope{NamespacedGroupVersionResource: locator.NamespacedGroupVersionResource}
+	switch {
+	case !locator.ReadOnly:
+		scope.LabelSelector = d.managedSelector
+	case locator.Selector != "":
+		scope.LabelSelector = locator.Selector
+	case locator.List:
+		// lists without a selector include every object
+	default:
+		scope.FieldSelector = fields.OneTermEqualSelector("metadata.name", locator.Name).String()
 	}
+	return scope
 }
 
-func (d *DynamicInformerCache) Get(ngvr identity.NamespacedGroupVersionResource) informers.GenericInformer {
-	informer, ok := d.informers.Load(ngvr)
+func (d *DynamicInformerCache) Get(locator *identity.Locator) informers.GenericInformer {
+	d.RLock()
+	defer d.RUnlock()
+	inf, ok := d.informers[d.Scope(locator)]
 	if !ok {
 		return nil
 	}
-	return informer.(informers.GenericInformer)
+	return inf.GenericInformer
 }
 
-func (d *DynamicInformerCache) Add(ngvr identity.NamespacedGroupVersionResource, factory NamespacedDynamicInformerFactory, stopc <-chan struct{}) informers.GenericInformer {
-	inf := factory(d.client, ngvr)
-	d.informers.Store(ngvr, inf)
-	go inf.Informer().Run(stopc)
-	return inf
+func (d *DynamicInformerCache) Watch(locator *identity.Locator, factory ScopedDynamicInformerFactory, stopc <-chan struct{}) informers.GenericInformer {
+	scope := d.Scope(locator)
+	path := strings.Join(locator.Path, "/")
+
+	d.Lock()
+	defer d.Unlock()
+	if previous, ok := d.scopes[path]; ok && previous != scope {
+		d.release(previous, path)
+	}
+	d.scopes[path] = scope
+
+	if inf, ok := d.informers[scope]; ok {
+		inf.Lock()
+		previous, ok := inf.locators[path]
+		inf.locators[path] = *locator
+		inf.Unlock()
+		if !ok || !previous.Equal(*locator) {
+			inf.replay(d.queue, *locator)
+		}
+		return inf.GenericInformer
+	}
+	inf := &scopedInformer{
+		GenericInformer: factory(d.client, scope),
+		stop:            make(chan struct{}),
+		locators:        map[string]identity.Locator{path: *locator},
+	}
+	dispatch := func(obj interface{}) { inf.dispatch(d.queue, obj) }
+	inf.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    dispatch,
+		UpdateFunc: func(_, obj interface{}) { dispatch(obj) },
+		DeleteFunc: dispatch,
+	})
+	d.informers[scope] = inf
+	metrics.Informers.Inc()
+	go func() {
+		defer metrics.Informers.Dec()
+		// stop when either the cache or the informer is stopped
+		informerStop := make(chan struct{})
+		go func() {
+			defer close(informerStop)
+			select {
+			case <-stopc:
+			case <-inf.stop:
+			}
+		}()
+		inf.Informer().Run(informerStop)
+	}()
+	klog.V(4).Infof("started informer for %s", scope)
+	return inf.GenericInformer
+}
+
+func (d *DynamicInformerCache) Unwatch(path ...string) {
+	key := strings.Join(path, "/")
+	d.Lock()
+	defer d.Unlock()
+	scope, ok := d.scopes[key]
+	if !ok {
+		return
+	}
+	delete(d.scopes, key)
+	d.release(scope, key)
+}
+
+func (d *DynamicInformerCache) HasSynced() bool {
+	d.RLock()
+	defer d.RUnlock()
+	for _, inf := range d.informers {
+		if !inf.Informer().HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// release removes path from the informer for scope, and stops it if no other path uses it. d must be locked.
+func (d *DynamicInformerCache) release(scope Scope, path string) {
+	inf, ok := d.informers[scope]
+	if !ok {
+		return
+	}
+	inf.Lock()
+	delete(inf.locators, path)
+	remaining := len(inf.locators)
+	inf.Unlock()
+	if remaining > 0 {
+		return
+	}
+	close(inf.stop)
+	delete(d.informers, scope)
+	klog.V(4).Infof("stopped informer for %s", scope)
 }
 
 // FromCluster returns a list of objects found in the cluster (cache) identified by locators
@@ -52,7 +212,21 @@ func (d *DynamicInformerCache) FromCluster(locators []*identity.Locator) (curren
 	current = make(map[*identity.Locator]*unstructured.Unstructured)
 
 	for _, o := range locators {
-		i := d.Get(o.NamespacedGroupVersionResource)
+		i := d.Get(o)
+		if i == nil {
+			klog.V(2).Infof("%s is not watched, cluster state is dirty", strings.Join(o.Path, "/"))
+			continue
+		}
+
+		if o.List {
+			list, err := list(i, o)
+			if err != nil {
+				klog.V(2).Infof("%s could not be listed from cache: %v", strings.Join(o.Path, "/"), err)
+				continue
+			}
+			current[o] = list
+			continue
+		}
 
 		var fetched runtime.Object
 		var err error
@@ -77,3 +251,39 @@ func (d *DynamicInformerCache) FromCluster(locators []*identity.Locator) (curren
 
 	return
 }
+
+// list returns an object with the objects identified by a list locator as its items, ordered by namespace and name
+func list(i informers.GenericInformer, l *identity.Locator) (*unstructured.Unstructured, error) {
+	selector, err := labels.Parse(l.Selector)
+	if err != nil {
+		return nil, err
+	}
+	var objs []runtime.Object
+	if l.Namespace != "" {
+		objs, err = i.Lister().ByNamespace(l.Namespace).List(selector)
+	} else {
+		objs, err = i.Lister().List(selector)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, o := range objs {
+		if u, ok := o.(*unstructured.Unstructured); ok {
+			sorted = append(sorted, u)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		if sorted[a].GetNamespace() != sorted[b].GetNamespace() {
+			return sorted[a].GetNamespace() < sorted[b].GetNamespace()
+		}
+		return sorted[a].GetName() < sorted[b].GetName()
+	})
+
+	items := make([]interface{}, 0, len(sorted))
+	for _, u := range sorted {
+		items = append(items, u.Object)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{"items": items}}, nil
+}
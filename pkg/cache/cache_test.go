@@ -0,0 +1,240 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cache_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+const managedSelector = "cuebectl.io/instance=test"
+
+func configMap(name string, data map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{"cuebectl.io/instance": "test"},
+		},
+		"data": data,
+	}}
+}
+
+func locator(name string) *identity.Locator {
+	return &identity.Locator{
+		NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: configMaps, Namespace: "default"},
+		Name:                           name,
+		Path:                           []string{name},
+	}
+}
+
+// settle waits for queue to receive at least n items, and a little longer for any extra items
+func settle(t *testing.T, queue workqueue.Interface, n int) int {
+	t.Helper()
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return queue.Len() >= n, nil
+	}); err != nil {
+		t.Fatalf("queue has %d items, want %d", queue.Len(), n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	return queue.Len()
+}
+
+// drain removes every item from queue
+func drain(queue workqueue.Interface) []*identity.LocatedUnstructured {
+	var items []*identity.LocatedUnstructured
+	for queue.Len() > 0 {
+		item, _ := queue.Get()
+		queue.Done(item)
+		items = append(items, item.(*identity.LocatedUnstructured))
+	}
+	return items
+}
+
+func TestWatchQueuesChangesOnce(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), configMap("config", map[string]interface{}{"key": "a"}))
+	queue := workqueue.New()
+	defer queue.ShutDown()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	d := cache.NewDynamicInformerCache(client, managedSelector, queue)
+	var inf informers.GenericInformer
+	for i := 0; i < 3; i++ {
+		inf = d.Watch(locator("config"), cache.DefaultScopedDynamicInformerFactory, stop)
+	}
+	if !toolscache.WaitForCacheSync(stop, inf.Informer().HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+	if n := settle(t, queue, 1); n != 1 {
+		t.Fatalf("got %d items for the initial object, want 1", n)
+	}
+	drain(queue)
+
+	if _, err := client.Resource(configMaps).Namespace("default").Update(context.Background(), configMap("config", map[string]interface{}{"key": "b"}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := settle(t, queue, 1); n != 1 {
+		t.Fatalf("got %d items for one update, want 1", n)
+	}
+	item := drain(queue)[0]
+	if got, _, _ := unstructured.NestedString(item.Object, "data", "key"); got != "b" {
+		t.Errorf("got data.key %q, want the updated value", got)
+	}
+	if len(item.Path) != 1 || item.Path[0] != "config" {
+		t.Errorf("got path %v, want [config]", item.Path)
+	}
+}
+
+func TestWatchDispatchesToMatchingLocators(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), configMap("a", nil), configMap("b", nil))
+	queue := workqueue.New()
+	defer queue.ShutDown()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	d := cache.NewDynamicInformerCache(client, managedSelector, queue)
+	infA := d.Watch(locator("a"), cache.DefaultScopedDynamicInformerFactory, stop)
+	infB := d.Watch(locator("b"), cache.DefaultScopedDynamicInformerFactory, stop)
+	if infA != infB {
+		t.Fatal("managed objects of the same resource and namespace should share an informer")
+	}
+	if !toolscache.WaitForCacheSync(stop, infA.Informer().HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+	settle(t, queue, 2)
+	drain(queue)
+
+	if _, err := client.Resource(configMaps).Namespace("default").Update(context.Background(), configMap("b", map[string]interface{}{"key": "b"}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := settle(t, queue, 1); n != 1 {
+		t.Fatalf("got %d items for one update, want 1", n)
+	}
+	if item := drain(queue)[0]; item.Path[0] != "b" {
+		t.Errorf("update of b was queued for %v", item.Path)
+	}
+}
+
+// recordingFactory builds informers like DefaultScopedDynamicInformerFactory, recording the watches they start
+type recordingFactory struct {
+	sync.Mutex
+	watches []watch.Interface
+}
+
+func (f *recordingFactory) factory(client dynamic.Interface, scope cache.Scope) informers.GenericInformer {
+	resource := client.Resource(scope.GroupVersionResource).Namespace(scope.Namespace)
+	informer := toolscache.NewSharedIndexInformer(&toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = scope.LabelSelector
+			return resource.List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = scope.LabelSelector
+			w, err := resource.Watch(context.Background(), options)
+			if err == nil {
+				f.Lock()
+				f.watches = append(f.watches, w)
+				f.Unlock()
+			}
+			return w, err
+		},
+	}, &unstructured.Unstructured{}, 0, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+	return &genericInformer{informer: informer, resource: scope.GroupResource()}
+}
+
+// stopped returns true once every recorded watch has been stopped
+func (f *recordingFactory) stopped() bool {
+	f.Lock()
+	defer f.Unlock()
+	for _, w := range f.watches {
+		if s, ok := w.(interface{ IsStopped() bool }); ok && !s.IsStopped() {
+			return false
+		}
+	}
+	return len(f.watches) > 0
+}
+
+type genericInformer struct {
+	informer toolscache.SharedIndexInformer
+	resource schema.GroupResource
+}
+
+func (i *genericInformer) Informer() toolscache.SharedIndexInformer { return i.informer }
+func (i *genericInformer) Lister() toolscache.GenericLister {
+	return toolscache.NewGenericLister(i.informer.GetIndexer(), i.resource)
+}
+
+func TestUnwatchStopsUnusedInformers(t *testing.T) {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme(), configMap("a", nil), configMap("b", nil))
+	queue := workqueue.New()
+	defer queue.ShutDown()
+	stop := make(chan struct{})
+	defer close(stop)
+	f := &recordingFactory{}
+
+	d := cache.NewDynamicInformerCache(client, managedSelector, queue)
+	inf := d.Watch(locator("a"), f.factory, stop)
+	d.Watch(locator("b"), f.factory, stop)
+	if !toolscache.WaitForCacheSync(stop, inf.Informer().HasSynced) {
+		t.Fatal("informer did not sync")
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		f.Lock()
+		defer f.Unlock()
+		return len(f.watches) > 0, nil
+	}); err != nil {
+		t.Fatal("informer did not start watching")
+	}
+
+	// the informer is still used by b
+	d.Unwatch("a")
+	if d.Get(locator("b")) == nil {
+		t.Fatal("informer was released while b still uses it")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if f.stopped() {
+		t.Fatal("informer was stopped while b still uses it")
+	}
+	settle(t, queue, 2)
+	drain(queue)
+	if _, err := client.Resource(configMaps).Namespace("default").Update(context.Background(), configMap("a", map[string]interface{}{"key": "a"}), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := queue.Len(); n != 0 {
+		t.Errorf("got %d items for an unwatched object, want 0", n)
+	}
+
+	d.Unwatch("b")
+	if d.Get(locator("b")) != nil {
+		t.Error("informer is still cached after its last path was unwatched")
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return f.stopped(), nil
+	}); err != nil {
+		t.Error("informer was not stopped after its last path was unwatched")
+	}
+}
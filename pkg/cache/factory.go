@@ -4,6 +4,9 @@
 package cache
 
 import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
@@ -12,10 +15,25 @@ import (
 	"github.com/cuebernetes/cuebectl/pkg/identity"
 )
 
-type NamespacedDynamicInformerFactory func(client dynamic.Interface, ngvr identity.NamespacedGroupVersionResource) informers.GenericInformer
+// Scope identifies the objects an informer watches: objects of a GVR in a namespace (or all namespaces), restricted
+// by field and label selectors
+type Scope struct {
+	identity.NamespacedGroupVersionResource
+	FieldSelector string
+	LabelSelector string
+}
+
+func (s Scope) String() string {
+	return fmt.Sprintf("%s %s (fields: %q, labels: %q)", s.GroupVersionResource, s.Namespace, s.FieldSelector, s.LabelSelector)
+}
+
+type ScopedDynamicInformerFactory func(client dynamic.Interface, scope Scope) informers.GenericInformer
 
-func DefaultNamespacedDynamicInformerFactory(client dynamic.Interface, ngvr identity.NamespacedGroupVersionResource) informers.GenericInformer {
-	return dynamicinformer.NewFilteredDynamicInformer(client, ngvr.GroupVersionResource, ngvr.Namespace, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil)
+func DefaultScopedDynamicInformerFactory(client dynamic.Interface, scope Scope) informers.GenericInformer {
+	return dynamicinformer.NewFilteredDynamicInformer(client, scope.GroupVersionResource, scope.Namespace, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, func(options *metav1.ListOptions) {
+		options.FieldSelector = scope.FieldSelector
+		options.LabelSelector = scope.LabelSelector
+	})
 }
 
-var _ NamespacedDynamicInformerFactory = DefaultNamespacedDynamicInformerFactory
+var _ ScopedDynamicInformerFactory = DefaultScopedDynamicInformerFactory
@@ -11,8 +11,18 @@ import (
 )
 
 type Interface interface {
-	Get(ngvr identity.NamespacedGroupVersionResource) informers.GenericInformer
-	Add(ngvr identity.NamespacedGroupVersionResource, factory NamespacedDynamicInformerFactory, stopc <-chan struct{}) informers.GenericInformer
+	// Get returns the running informer that watches the object(s) identified by locator, or nil
+	Get(locator *identity.Locator) informers.GenericInformer
+
+	// Watch returns an informer that watches the object(s) identified by locator, starting one if needed. The
+	// informer is used for the locator's path until the path watches a different scope or is unwatched.
+	Watch(locator *identity.Locator, factory ScopedDynamicInformerFactory, stopc <-chan struct{}) informers.GenericInformer
+
+	// Unwatch releases the informer used for path, stopping it if no other path uses it
+	Unwatch(path ...string)
+
+	// HasSynced returns true once every running informer has synced
+	HasSynced() bool
 
 	FromCluster(locators []*identity.Locator) (current map[*identity.Locator]*unstructured.Unstructured)
 }
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cache
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/informers"
+
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+var _ Interface = &MultiClusterCache{}
+
+// MultiClusterCache watches each locator with the cache for its kube context
+type MultiClusterCache struct {
+	caches map[string]Interface
+}
+
+// NewMultiClusterCache returns a cache from a cache per kube context, where "" is the default cluster
+func NewMultiClusterCache(caches map[string]Interface) *MultiClusterCache {
+	return &MultiClusterCache{caches: caches}
+}
+
+// Get returns the informer for locator, or nil if it isn't watched or its context is unknown
+func (m *MultiClusterCache) Get(locator *identity.Locator) informers.GenericInformer {
+	c, ok := m.caches[locator.Context]
+	if !ok {
+		return nil
+	}
+	return c.Get(locator)
+}
+
+// Watch returns the informer for locator from the cache for its context. Locators must have a known context.
+func (m *MultiClusterCache) Watch(locator *identity.Locator, factory ScopedDynamicInformerFactory, stopc <-chan struct{}) informers.GenericInformer {
+	return m.caches[locator.Context].Watch(locator, factory, stopc)
+}
+
+// Unwatch releases the informers used for path in every context
+func (m *MultiClusterCache) Unwatch(path ...string) {
+	for _, c := range m.caches {
+		c.Unwatch(path...)
+	}
+}
+
+// HasSynced returns true once the informers of every context have synced
+func (m *MultiClusterCache) HasSynced() bool {
+	for _, c := range m.caches {
+		if !c.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// FromCluster returns the objects identified by locators, each read from the cache for its context
+func (m *MultiClusterCache) FromCluster(locators []*identity.Locator) map[*identity.Locator]*unstructured.Unstructured {
+	byContext := map[string][]*identity.Locator{}
+	for _, l := range locators {
+		byContext[l.Context] = append(byContext[l.Context], l)
+	}
+	current := make(map[*identity.Locator]*unstructured.Unstructured, len(locators))
+	for context, ls := range byContext {
+		c, ok := m.caches[context]
+		if !ok {
+			continue
+		}
+		for l, u := range c.FromCluster(ls) {
+			current[l] = u
+		}
+	}
+	return current
+}
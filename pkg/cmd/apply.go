@@ -4,16 +4,30 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
+	"cuelang.org/go/cue"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/dynamic"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/util/templates"
 
 	"github.com/cuebernetes/cuebectl/pkg/apply"
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+	"github.com/cuebernetes/cuebectl/pkg/facts"
+	"github.com/cuebernetes/cuebectl/pkg/leader"
+	"github.com/cuebernetes/cuebectl/pkg/metrics"
+	"github.com/cuebernetes/cuebectl/pkg/policy"
+	"github.com/cuebernetes/cuebectl/pkg/preflight"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
 	"github.com/cuebernetes/cuebectl/pkg/signals"
 )
 
@@ -23,7 +37,10 @@ var (
 
 	applyExample = templates.Examples(`
 		# Apply a folder with cue definitions to a cluster
-		%[1]s apply example`)
+		%[1]s apply example
+
+		# Continuously apply a folder from multiple replicas, with only one active at a time
+		%[1]s apply --watch --leader-elect example`)
 )
 
 // ApplyOptions contains the input to the apply command.
@@ -34,7 +51,20 @@ type ApplyOptions struct {
 	Namespace         string
 	ExplicitNamespace bool
 	Watch             bool
+	Instance          string
+	Adopt             bool
+	ForceAdopt        bool
+	Prune             bool
+	MetricsAddr       string
+	RecordEvents      bool
+	Backend           string
+	OutputDir         string
+	SkipPreflight     bool
+	Policy            string
+	ShowSecrets       bool
 
+	LeaderElectOptions
+	ClientOptions
 	resource.FilenameOptions
 	genericclioptions.IOStreams
 }
@@ -43,6 +73,7 @@ type ApplyOptions struct {
 func NewApplyOptions(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *ApplyOptions {
 	return &ApplyOptions{
 		configFlags: flags,
+		Backend:     ensure.DefaultBackend,
 		CmdParent:   parent,
 		IOStreams:   streams,
 	}
@@ -68,6 +99,19 @@ func NewCmdApply(parent string, flags *genericclioptions.ConfigFlags, streams ge
 
 	cmd.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for %s apply", parent))
 	cmd.Flags().BoolP("watch", "w", false, "after creating resources, continue to watch cluster state")
+	cmd.Flags().StringVar(&o.Instance, "instance", o.Instance, "name of the instance, used to label managed objects (defaults to the cue package name)")
+	cmd.Flags().BoolVar(&o.Adopt, "adopt", o.Adopt, "adopt existing objects that are not managed by cuebectl")
+	cmd.Flags().BoolVar(&o.ForceAdopt, "force-adopt", o.ForceAdopt, "adopt existing objects even if they are managed by another cuebectl instance")
+	cmd.Flags().BoolVar(&o.Prune, "prune", o.Prune, "with --watch, delete the objects of fields that are no longer generated by the instance")
+	cmd.Flags().BoolVar(&o.RecordEvents, "record-events", o.RecordEvents, "record events for changes to managed objects, and for fields without objects on an inventory ConfigMap")
+	cmd.Flags().StringVar(&o.Backend, "backend", o.Backend, fmt.Sprintf("how objects are applied, one of: %s", backendUsage()))
+	cmd.Flags().StringVar(&o.OutputDir, "output-dir", o.OutputDir, "directory the yaml backend writes objects to")
+	cmd.Flags().StringVar(&o.Policy, "policy", o.Policy, "directory of a cue package with deny and warn constraints for objects, keyed by apiVersion/kind")
+	cmd.Flags().BoolVar(&o.ShowSecrets, "show-secrets", o.ShowSecrets, "show Secret data and fields marked with @cuebectl(sensitive) in errors, events and logs, for local debugging")
+	cmd.Flags().BoolVar(&o.SkipPreflight, "skip-preflight", o.SkipPreflight, "apply without first checking that you have the permissions every field needs")
+	cmd.Flags().StringVar(&o.MetricsAddr, "metrics-addr", o.MetricsAddr, "address to serve /metrics, /healthz and /readyz on, e.g. :8080 (disabled if empty)")
+	o.ClientOptions.AddFlags(cmd.Flags())
+	o.LeaderElectOptions.AddFlags(cmd.Flags(), "")
 	o.configFlags.AddFlags(cmd.Flags())
 
 	return cmd
@@ -93,12 +137,34 @@ func (o *ApplyOptions) Validate(cmd *cobra.Command, args []string) error {
 	if len(args) == 0 && cmdutil.IsFilenameSliceEmpty(o.Filenames, o.Kustomize) {
 		return fmt.Errorf("must supply a path to cue files")
 	}
-	return nil
+	if o.Instance != "" && o.Instance != ensure.InstanceName(o.Instance) {
+		return fmt.Errorf("invalid instance name %q: must be a valid label value", o.Instance)
+	}
+	if o.LeaderElect && !o.Watch {
+		return fmt.Errorf("--leader-elect requires --watch")
+	}
+	backend, err := ensure.Lookup(o.Backend)
+	if err != nil {
+		return err
+	}
+	if backend.Cluster != ensure.LiveCluster && o.RecordEvents {
+		return fmt.Errorf("--record-events can't be used with the %s backend, which doesn't write to the cluster", o.Backend)
+	}
+	if o.OutputDir != "" && o.Backend != "yaml" {
+		return fmt.Errorf("--output-dir requires --backend=yaml")
+	}
+	if err := ValidateImpersonation(o.configFlags); err != nil {
+		return err
+	}
+	if err := o.ClientOptions.Validate(); err != nil {
+		return err
+	}
+	return o.LeaderElectOptions.Validate()
 }
 
 // Run performs the apply operation.
 func (o *ApplyOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
-	client, err := f.DynamicClient()
+	client, err := o.ClientOptions.DynamicClient(f)
 	if err != nil {
 		return err
 	}
@@ -106,6 +172,150 @@ func (o *ApplyOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string)
 	if err != nil {
 		return err
 	}
-	_, err = apply.CueDir(signals.Context(), o.IOStreams.Out, client, mapper, args[0], o.Watch)
-	return err
+	cluster, err := o.clusterFacts(f)
+	if err != nil {
+		return err
+	}
+	r, b, instance, err := apply.Load(args[0], cluster)
+	if err != nil {
+		return err
+	}
+	options := reconcile.Options{
+		Name:        o.Instance,
+		Adopt:       o.Adopt,
+		ForceAdopt:  o.ForceAdopt,
+		Prune:       o.Prune,
+		Workers:     o.Concurrency,
+		Backend:     o.Backend,
+		Target:      ensure.Target{Dir: o.OutputDir, Out: o.IOStreams.Out},
+		Impersonate: o.ClientOptions.Impersonator(f),
+		ShowSecrets: o.ShowSecrets,
+	}
+	if options.Name == "" {
+		options.Name = apply.DefaultName(b)
+	}
+	if o.Policy != "" {
+		if options.Policy, err = policy.Load(o.Policy); err != nil {
+			return fmt.Errorf("could not load policy: %v", err)
+		}
+	}
+	contexts, err := attributes.Contexts(instance.Value())
+	if err != nil {
+		return err
+	}
+	if options.Clusters, err = o.ClientOptions.Clusters(o.configFlags, contexts); err != nil {
+		return err
+	}
+	if err := o.preflight(client, mapper, options, r, instance); err != nil {
+		return err
+	}
+	if o.RecordEvents {
+		clientset, err := f.KubernetesClientSet()
+		if err != nil {
+			return err
+		}
+		inventory, err := ensure.EnsureInventory(context.TODO(), client, o.Namespace, options.Name)
+		if err != nil {
+			return err
+		}
+		broadcaster, recorder := events.NewBroadcaster(clientset)
+		defer broadcaster.Shutdown()
+		options.Recorder = events.NewRecorder(recorder, inventory)
+	}
+
+	ctx := signals.Context()
+	var server *metrics.Server
+	if o.MetricsAddr != "" {
+		server = metrics.NewServer(o.MetricsAddr)
+		server.Start(ctx)
+		options.Ready = func() { server.SetReady(true) }
+	}
+	run := func(ctx context.Context) error {
+		_, err := apply.CueInstance(ctx, o.IOStreams.Out, client, mapper, r, instance, o.Watch, options)
+		return err
+	}
+	if !o.LeaderElect {
+		return run(ctx)
+	}
+
+	// replicas applying the same instance share a lease, and the new leader picks up the objects of the old one
+	o.LeaderElectOptions.Complete(o.Namespace, options.Name)
+	clientset, err := f.KubernetesClientSet()
+	if err != nil {
+		return err
+	}
+	// standby replicas are ready to take over, and the leader is ready once it has synced
+	if server != nil {
+		server.SetReady(true)
+	}
+	var runErr error
+	if err := leader.Run(ctx, clientset, o.Config, func(ctx context.Context) {
+		if server != nil {
+			server.SetReady(false)
+		}
+		runErr = run(ctx)
+	}); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// preflight checks that the user has the permissions that the fields of instance need, before anything is written.
+// Backends that don't write to the cluster aren't checked.
+func (o *ApplyOptions) preflight(client dynamic.Interface, mapper meta.RESTMapper, options reconcile.Options, r *cue.Runtime, instance *cue.Instance) error {
+	backend, err := ensure.Lookup(o.Backend)
+	if err != nil {
+		return err
+	}
+	if o.SkipPreflight || backend.Cluster != ensure.LiveCluster {
+		return nil
+	}
+	clusters := map[string]preflight.Cluster{"": {Client: client, Mapper: mapper, Impersonate: options.Impersonate}}
+	for name, c := range options.Clusters {
+		clusters[name] = preflight.Cluster{Client: c.Client, Mapper: c.Mapper, Impersonate: c.Impersonate}
+	}
+	permissions, skipped, err := preflight.Permissions(r, instance, clusters)
+	if err != nil {
+		return err
+	}
+	for _, s := range skipped {
+		fmt.Fprintf(o.ErrOut, "could not check the permissions for %s before applying: %v\n", s.Path, s.Err)
+	}
+	denials, err := preflight.Check(context.TODO(), clusters, permissions)
+	if err != nil {
+		return fmt.Errorf("preflight failed, rerun with --skip-preflight to apply anyway: %v", err)
+	}
+	if len(denials) == 0 {
+		return nil
+	}
+	if err := preflight.PrintDenials(o.ErrOut, denials); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d permissions needed by the instance are denied, rerun with --skip-preflight to apply anyway", len(denials))
+}
+
+// clusterFacts discovers the facts about the target cluster that are exposed to cue
+func (o *ApplyOptions) clusterFacts(f cmdutil.Factory) (*facts.Cluster, error) {
+	discoveryClient, err := f.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	rawConfig, err := f.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	context := rawConfig.CurrentContext
+	if o.configFlags.Context != nil && *o.configFlags.Context != "" {
+		context = *o.configFlags.Context
+	}
+	return facts.Discover(discoveryClient, context, o.Namespace)
+}
+
+// backendUsage describes the registered ensurer backends
+func backendUsage() string {
+	var usage []string
+	for _, b := range ensure.Backends() {
+		usage = append(usage, fmt.Sprintf("%s (%s)", b.Name, b.Description))
+	}
+	return strings.Join(usage, ", ")
 }
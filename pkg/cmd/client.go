@@ -0,0 +1,133 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/ratelimit"
+)
+
+// ClientOptions configure the concurrency and rate limits of commands that sync instances.
+type ClientOptions struct {
+	Concurrency   int
+	QPS           float32
+	Burst         int
+	ResourceQPS   float32
+	ResourceBurst int
+}
+
+// AddFlags adds concurrency and rate limit flags to flags.
+func (o *ClientOptions) AddFlags(flags *pflag.FlagSet) {
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+	flags.IntVar(&o.Concurrency, "concurrency", o.Concurrency, "number of labels and cluster changes to process concurrently")
+	flags.Float32Var(&o.QPS, "qps", o.QPS, "maximum requests per second to the apiserver (defaults to the client-go default)")
+	flags.IntVar(&o.Burst, "burst", o.Burst, "maximum burst of requests to the apiserver (defaults to the client-go default)")
+	flags.Float32Var(&o.ResourceQPS, "resource-qps", o.ResourceQPS, "maximum requests per second for each resource type (unlimited if 0)")
+	flags.IntVar(&o.ResourceBurst, "resource-burst", o.ResourceBurst, "maximum burst of requests for each resource type (defaults to --concurrency)")
+}
+
+// ValidateImpersonation checks that groups are only impersonated along with a user, since the apiserver rejects
+// requests that impersonate groups alone.
+func ValidateImpersonation(flags *genericclioptions.ConfigFlags) error {
+	if flags.ImpersonateGroup != nil && len(*flags.ImpersonateGroup) > 0 && (flags.Impersonate == nil || *flags.Impersonate == "") {
+		return fmt.Errorf("--as-group requires --as")
+	}
+	return nil
+}
+
+// Validate checks the concurrency and rate limits.
+func (o *ClientOptions) Validate() error {
+	if o.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if o.QPS < 0 || o.Burst < 0 || o.ResourceQPS < 0 || o.ResourceBurst < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+	return nil
+}
+
+// DynamicClient returns a dynamic client with the configured rate limits.
+func (o *ClientOptions) DynamicClient(f cmdutil.Factory) (dynamic.Interface, error) {
+	config, err := f.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	return o.dynamicClient(config)
+}
+
+// Impersonator returns an impersonator for the cluster of f. Its clients have the configured rate limits, and
+// impersonate the given user instead of --as and --as-group, so the credentials from the kubeconfig must be allowed
+// to impersonate the user.
+func (o *ClientOptions) Impersonator(f cmdutil.Factory) ensure.Impersonator {
+	return func(user string) (dynamic.Interface, error) {
+		config, err := f.ToRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		config = rest.CopyConfig(config)
+		config.Impersonate = rest.ImpersonationConfig{UserName: user}
+		return o.dynamicClient(config)
+	}
+}
+
+// dynamicClient returns a dynamic client for config with the configured rate limits.
+func (o *ClientOptions) dynamicClient(config *rest.Config) (dynamic.Interface, error) {
+	if o.QPS > 0 {
+		config.QPS = o.QPS
+	}
+	if o.Burst > 0 {
+		config.Burst = o.Burst
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if o.ResourceQPS == 0 {
+		return client, nil
+	}
+	burst := o.ResourceBurst
+	if burst == 0 {
+		burst = o.Concurrency
+	}
+	return ratelimit.NewDynamicClient(client, o.ResourceQPS, burst), nil
+}
+
+// Clusters returns a cluster for each kube context in contexts, read from the same kubeconfig as flags. Flags that
+// aren't specific to a cluster, such as impersonation, apply to every cluster.
+func (o *ClientOptions) Clusters(flags *genericclioptions.ConfigFlags, contexts []string) (map[string]controller.Cluster, error) {
+	clusters := make(map[string]controller.Cluster, len(contexts))
+	for _, name := range contexts {
+		name := name
+		contextFlags := genericclioptions.NewConfigFlags(true)
+		contextFlags.KubeConfig = flags.KubeConfig
+		contextFlags.Impersonate = flags.Impersonate
+		contextFlags.ImpersonateGroup = flags.ImpersonateGroup
+		contextFlags.Insecure = flags.Insecure
+		contextFlags.Timeout = flags.Timeout
+		contextFlags.Context = &name
+
+		f := cmdutil.NewFactory(contextFlags)
+		client, err := o.DynamicClient(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not create a client for context %q: %v", name, err)
+		}
+		mapper, err := f.ToRESTMapper()
+		if err != nil {
+			return nil, fmt.Errorf("could not create a client for context %q: %v", name, err)
+		}
+		clusters[name] = controller.Cluster{Client: client, Mapper: mapper, Impersonate: o.Impersonator(f)}
+	}
+	return clusters, nil
+}
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/cuebernetes/cuebectl/pkg/events"
+	"github.com/cuebernetes/cuebectl/pkg/leader"
+	"github.com/cuebernetes/cuebectl/pkg/metrics"
+	"github.com/cuebernetes/cuebectl/pkg/operator"
+	"github.com/cuebernetes/cuebectl/pkg/signals"
+)
+
+var (
+	controllerLong = templates.LongDesc(`
+		Run as a controller that applies the cue definitions referenced by CueInstance resources, and keeps them
+		unified with the cluster state.
+
+		The source of a CueInstance is inline files, a ConfigMap in the same namespace, or a path below
+		--source-root on the controller's filesystem. Progress, managed objects, and errors are reported in the CueInstance's status.
+
+		Each CueInstance may only apply and reference objects in its own namespace, unless
+		--allow-cross-namespace is set.`)
+
+	controllerExample = templates.Examples(`
+		# Reconcile CueInstances in the current namespace
+		%[1]s controller
+
+		# Reconcile CueInstances in all namespaces, with leader election
+		%[1]s controller --all-namespaces --leader-elect`)
+)
+
+// ControllerOptions contains the input to the controller command.
+type ControllerOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+
+	CmdParent     string
+	Namespace     string
+	AllNamespaces bool
+	ResyncPeriod  time.Duration
+	MetricsAddr   string
+
+	AllowCrossNamespace bool
+	SourceRoot          string
+	ShowSecrets         bool
+
+	LeaderElectOptions
+	ClientOptions
+
+	genericclioptions.IOStreams
+}
+
+// NewControllerOptions
+func NewControllerOptions(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *ControllerOptions {
+	return &ControllerOptions{
+		configFlags:  flags,
+		CmdParent:    parent,
+		ResyncPeriod: 5 * time.Minute,
+		IOStreams:    streams,
+	}
+}
+
+// NewCmdController creates a command object for the "controller"
+func NewCmdController(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *cobra.Command {
+	f := cmdutil.NewFactory(flags)
+	o := NewControllerOptions(parent, flags, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "controller [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Reconcile CueInstance resources",
+		Long:                  controllerLong,
+		Example:               fmt.Sprintf(controllerExample, parent),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Complete(f, cmd, args))
+			cmdutil.CheckErr(o.Validate(cmd, args))
+			cmdutil.CheckErr(o.Run(f, cmd, args))
+		},
+	}
+
+	cmd.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for %s controller", parent))
+	cmd.Flags().BoolVarP(&o.AllNamespaces, "all-namespaces", "A", o.AllNamespaces, "reconcile CueInstances in all namespaces")
+	cmd.Flags().DurationVar(&o.ResyncPeriod, "resync-period", o.ResyncPeriod, "how often the source of each CueInstance is re-read")
+	cmd.Flags().StringVar(&o.MetricsAddr, "metrics-addr", o.MetricsAddr, "address to serve /metrics, /healthz and /readyz on, e.g. :8080 (disabled if empty)")
+	cmd.Flags().BoolVar(&o.AllowCrossNamespace, "allow-cross-namespace", o.AllowCrossNamespace, "let CueInstances manage cluster-scoped objects and objects in other namespaces")
+	cmd.Flags().StringVar(&o.SourceRoot, "source-root", o.SourceRoot, "directory that CueInstances may read spec.source.path from (spec.source.path is disabled if empty)")
+	cmd.Flags().BoolVar(&o.ShowSecrets, "show-secrets", o.ShowSecrets, "show Secret data and fields marked with @cuebectl(sensitive) in the status of CueInstances, events and logs, for debugging")
+	o.ClientOptions.AddFlags(cmd.Flags())
+	o.LeaderElectOptions.AddFlags(cmd.Flags(), "cuebectl-controller")
+	o.configFlags.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// Complete takes the command arguments and factory and infers any remaining options.
+func (o *ControllerOptions) Complete(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var err error
+
+	o.Namespace, _, err = f.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+	o.LeaderElectOptions.Complete(o.Namespace, "controller")
+	return nil
+}
+
+// Validate checks the set of flags provided by the user.
+func (o *ControllerOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if o.ResyncPeriod <= 0 {
+		return fmt.Errorf("--resync-period must be positive")
+	}
+	if err := ValidateImpersonation(o.configFlags); err != nil {
+		return err
+	}
+	if err := o.ClientOptions.Validate(); err != nil {
+		return err
+	}
+	return o.LeaderElectOptions.Validate()
+}
+
+// Run runs the controller until interrupted.
+func (o *ControllerOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	client, err := o.ClientOptions.DynamicClient(f)
+	if err != nil {
+		return err
+	}
+	mapper, err := f.ToRESTMapper()
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := f.ToDiscoveryClient()
+	if err != nil {
+		return err
+	}
+	clientset, err := f.KubernetesClientSet()
+	if err != nil {
+		return err
+	}
+	namespace := o.Namespace
+	if o.AllNamespaces {
+		namespace = ""
+	}
+	broadcaster, recorder := events.NewBroadcaster(clientset)
+	defer broadcaster.Shutdown()
+
+	ctx := signals.Context()
+	var server *metrics.Server
+	var ready func()
+	if o.MetricsAddr != "" {
+		server = metrics.NewServer(o.MetricsAddr)
+		server.Start(ctx)
+		ready = func() { server.SetReady(true) }
+	}
+	run := func(ctx context.Context) error {
+		return operator.NewOperator(client, mapper, discoveryClient, operator.Options{
+			Namespace:    namespace,
+			ResyncPeriod: o.ResyncPeriod,
+			Workers:      o.Concurrency,
+			Recorder:     recorder,
+			Impersonate:  o.ClientOptions.Impersonator(f),
+			Ready:        ready,
+
+			AllowCrossNamespace: o.AllowCrossNamespace,
+			SourceRoot:          o.SourceRoot,
+			ShowSecrets:         o.ShowSecrets,
+		}).Run(ctx)
+	}
+	if !o.LeaderElect {
+		return run(ctx)
+	}
+
+	// standby replicas are ready to take over, and the leader is ready once it has synced
+	if server != nil {
+		server.SetReady(true)
+	}
+	var runErr error
+	if err := leader.Run(ctx, clientset, o.Config, func(ctx context.Context) {
+		if server != nil {
+			server.SetReady(false)
+		}
+		runErr = run(ctx)
+	}); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/cuebernetes/cuebectl/pkg/importer"
+	"github.com/cuebernetes/cuebectl/pkg/manifest"
+)
+
+var (
+	importLong = templates.LongDesc(`
+		Import existing kube definitions into cue.
+
+		Objects are read from yaml or json files, or with --from-cluster, from the cluster. Each object becomes a
+		top-level field in the generated package, with fields populated by the server removed. With --link, literal
+		references between imported objects (namespaces, roleRefs, service accounts, config maps, ...) are replaced
+		by references to the imported fields.`)
+
+	importExample = templates.Examples(`
+		# Import manifests into a cue package, linking references between them
+		%[1]s import --link --package app -o example/app.cue manifests/
+
+		# Import all deployments and services with the label app=nginx from the current namespace
+		%[1]s import --from-cluster deployments,services -l app=nginx`)
+)
+
+// ImportOptions contains the input to the import command.
+type ImportOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+
+	CmdParent         string
+	Namespace         string
+	ExplicitNamespace bool
+	AllNamespaces     bool
+	FromCluster       bool
+	Selector          string
+	Package           string
+	Link              bool
+	OutputFile        string
+
+	genericclioptions.IOStreams
+}
+
+// NewImportOptions returns initialized ImportOptions
+func NewImportOptions(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *ImportOptions {
+	return &ImportOptions{
+		configFlags: flags,
+		CmdParent:   parent,
+		IOStreams:   streams,
+	}
+}
+
+// NewCmdImport creates a command object for "import"
+func NewCmdImport(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *cobra.Command {
+	f := cmdutil.NewFactory(flags)
+	o := NewImportOptions(parent, flags, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "import [files | --from-cluster TYPE[,TYPE...]] [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Import kube definitions into cue",
+		Long:                  importLong,
+		Example:               fmt.Sprintf(importExample, parent),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Complete(f, cmd, args))
+			cmdutil.CheckErr(o.Validate(cmd, args))
+			cmdutil.CheckErr(o.Run(f, cmd, args))
+		},
+	}
+
+	cmd.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for %s import", parent))
+	cmd.Flags().BoolVar(&o.FromCluster, "from-cluster", o.FromCluster, "import objects of the given types from the cluster instead of files")
+	cmd.Flags().StringVarP(&o.Selector, "selector", "l", o.Selector, "label selector for objects imported from the cluster")
+	cmd.Flags().BoolVarP(&o.AllNamespaces, "all-namespaces", "A", o.AllNamespaces, "import objects from all namespaces")
+	cmd.Flags().StringVar(&o.Package, "package", "", "name of the generated cue package")
+	cmd.Flags().BoolVar(&o.Link, "link", o.Link, "replace literal references between imported objects with cue references")
+	cmd.Flags().StringVarP(&o.OutputFile, "output-file", "o", o.OutputFile, "write the generated cue to a file instead of stdout")
+	o.configFlags.AddFlags(cmd.Flags())
+
+	cmd.AddCommand(NewCmdImportSchemas(parent, flags, streams))
+
+	return cmd
+}
+
+// Complete takes the command arguments and factory and infers any remaining options.
+func (o *ImportOptions) Complete(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var err error
+
+	o.Namespace, o.ExplicitNamespace, err = f.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Validate checks the set of flags provided by the user.
+func (o *ImportOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		if o.FromCluster {
+			return fmt.Errorf("must supply the types of objects to import from the cluster")
+		}
+		return fmt.Errorf("must supply paths to yaml or json files")
+	}
+	if !o.FromCluster && (o.Selector != "" || o.AllNamespaces) {
+		return fmt.Errorf("--selector and --all-namespaces can only be used with --from-cluster")
+	}
+	return nil
+}
+
+// Run performs the import operation.
+func (o *ImportOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var objs []*unstructured.Unstructured
+	var err error
+	if o.FromCluster {
+		objs, err = o.fromCluster(f, args)
+	} else {
+		objs, err = manifest.ReadFiles(args...)
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := importer.Import(objs, importer.Options{Package: o.Package, Link: o.Link})
+	if err != nil {
+		return err
+	}
+
+	if o.OutputFile != "" {
+		return ioutil.WriteFile(o.OutputFile, b, 0644)
+	}
+	_, err = o.Out.Write(b)
+	return err
+}
+
+func (o *ImportOptions) fromCluster(f cmdutil.Factory, args []string) ([]*unstructured.Unstructured, error) {
+	infos, err := f.NewBuilder().
+		Unstructured().
+		NamespaceParam(o.Namespace).DefaultNamespace().AllNamespaces(o.AllNamespaces).
+		LabelSelectorParam(o.Selector).
+		ResourceTypeOrNameArgs(true, args...).
+		ContinueOnError().
+		Latest().
+		Flatten().
+		Do().
+		Infos()
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(infos))
+	for _, info := range infos {
+		u, ok := info.Object.(*unstructured.Unstructured)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type %T for %s", info.Object, info.Name)
+		}
+		objs = append(objs, u)
+	}
+	return objs, nil
+}
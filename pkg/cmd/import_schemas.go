@@ -0,0 +1,184 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/cuebernetes/cuebectl/pkg/manifest"
+	"github.com/cuebernetes/cuebectl/pkg/schemas"
+)
+
+var (
+	importSchemasLong = templates.LongDesc(`
+		Generate cue definitions for the kinds served by a cluster.
+
+		Definitions are written to cue.mod/gen/<group>/<version> in the target module, and fix apiVersion and kind.
+		Schemas for custom resources are read from the cluster's CustomResourceDefinitions; other kinds get open
+		definitions. With --crd-file, only the CustomResourceDefinitions in the given files are used and the cluster
+		is not contacted.`)
+
+	importSchemasExample = templates.Examples(`
+		# Generate definitions for every kind in the current cluster into the example module
+		%[1]s import schemas example
+
+		# Generate definitions from a CRD manifest without contacting a cluster
+		%[1]s import schemas example --crd-file crds.yaml`)
+
+	crdResources = []schema.GroupVersionResource{
+		{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"},
+		{Group: "apiextensions.k8s.io", Version: "v1beta1", Resource: "customresourcedefinitions"},
+	}
+)
+
+// ImportSchemasOptions contains the input to the import schemas command.
+type ImportSchemasOptions struct {
+	configFlags *genericclioptions.ConfigFlags
+
+	CmdParent string
+	Dir       string
+	CRDFiles  []string
+
+	genericclioptions.IOStreams
+}
+
+// NewImportSchemasOptions returns initialized ImportSchemasOptions
+func NewImportSchemasOptions(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *ImportSchemasOptions {
+	return &ImportSchemasOptions{
+		configFlags: flags,
+		CmdParent:   parent,
+		Dir:         ".",
+		IOStreams:   streams,
+	}
+}
+
+// NewCmdImportSchemas creates a command object for "import schemas"
+func NewCmdImportSchemas(parent string, flags *genericclioptions.ConfigFlags, streams genericclioptions.IOStreams) *cobra.Command {
+	f := cmdutil.NewFactory(flags)
+	o := NewImportSchemasOptions(parent, flags, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "schemas [dir] [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Generate cue definitions from cluster api resources and CRDs",
+		Long:                  importSchemasLong,
+		Example:               fmt.Sprintf(importSchemasExample, parent),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Complete(f, cmd, args))
+			cmdutil.CheckErr(o.Validate(cmd, args))
+			cmdutil.CheckErr(o.Run(f, cmd, args))
+		},
+	}
+
+	cmd.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for %s import schemas", parent))
+	cmd.Flags().StringSliceVar(&o.CRDFiles, "crd-file", o.CRDFiles, "read CustomResourceDefinitions from these files instead of the cluster")
+	o.configFlags.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// Complete takes the command arguments and factory and infers any remaining options.
+func (o *ImportSchemasOptions) Complete(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		o.Dir = args[0]
+	}
+	return nil
+}
+
+// Validate checks the set of flags provided by the user.
+func (o *ImportSchemasOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("at most one module directory may be specified")
+	}
+	return nil
+}
+
+// Run generates and writes the schemas.
+func (o *ImportSchemasOptions) Run(f cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	g := schemas.NewGenerator()
+
+	if len(o.CRDFiles) > 0 {
+		crds, err := manifest.ReadFiles(o.CRDFiles...)
+		if err != nil {
+			return err
+		}
+		if err := addCRDs(g, crds); err != nil {
+			return err
+		}
+	} else {
+		client, err := f.DynamicClient()
+		if err != nil {
+			return err
+		}
+		crds, err := listCRDs(client)
+		if err != nil {
+			return err
+		}
+		if err := addCRDs(g, crds); err != nil {
+			return err
+		}
+		discoveryClient, err := f.ToDiscoveryClient()
+		if err != nil {
+			return err
+		}
+		if err := g.AddDiscovery(discoveryClient); err != nil {
+			return err
+		}
+	}
+
+	written, err := g.Write(o.Dir)
+	if err != nil {
+		return err
+	}
+	for _, w := range written {
+		if _, err := fmt.Fprintf(o.Out, "wrote %s\n", w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addCRDs(g *schemas.Generator, crds []*unstructured.Unstructured) error {
+	for _, crd := range crds {
+		if crd.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		if err := g.AddCRD(crd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listCRDs lists CustomResourceDefinitions from the newest version of the api that the cluster serves
+func listCRDs(client dynamic.Interface) ([]*unstructured.Unstructured, error) {
+	var err error
+	for _, gvr := range crdResources {
+		var list *unstructured.UnstructuredList
+		list, err = client.Resource(gvr).List(context.TODO(), metav1.ListOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		crds := make([]*unstructured.Unstructured, 0, len(list.Items))
+		for i := range list.Items {
+			crds = append(crds, &list.Items[i])
+		}
+		return crds, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/leader"
+)
+
+// LeaderElectOptions configures leader election for long-running commands.
+type LeaderElectOptions struct {
+	LeaderElect          bool
+	LeaderElectNamespace string
+	leader.Config
+}
+
+// AddFlags adds leader election flags to flags. The lease name defaults to name, or is derived from the instance
+// name in Complete if name is empty.
+func (o *LeaderElectOptions) AddFlags(flags *pflag.FlagSet, name string) {
+	o.Config = leader.NewConfig("", name)
+	flags.BoolVar(&o.LeaderElect, "leader-elect", o.LeaderElect, "only run while holding a lease, so that multiple replicas can run")
+	flags.StringVar(&o.LeaderElectNamespace, "leader-elect-namespace", o.LeaderElectNamespace, "namespace of the lease (defaults to the current namespace)")
+	flags.StringVar(&o.Name, "leader-elect-name", o.Name, "name of the lease")
+	flags.DurationVar(&o.LeaseDuration, "leader-elect-lease-duration", o.LeaseDuration, "how long non-leaders wait before trying to acquire a lease that hasn't been renewed")
+	flags.DurationVar(&o.RenewDeadline, "leader-elect-renew-deadline", o.RenewDeadline, "how long the leader retries renewing the lease before giving up leadership")
+	flags.DurationVar(&o.RetryPeriod, "leader-elect-retry-period", o.RetryPeriod, "how long to wait between attempts to acquire or renew the lease")
+}
+
+// Complete defaults the lease namespace and name.
+func (o *LeaderElectOptions) Complete(namespace, name string) {
+	o.Namespace = o.LeaderElectNamespace
+	if o.Namespace == "" {
+		o.Namespace = namespace
+	}
+	if o.Name == "" {
+		o.Name = ensure.ResourceName(name)
+	}
+}
+
+// Validate checks that the lease durations are consistent.
+func (o *LeaderElectOptions) Validate() error {
+	if !o.LeaderElect {
+		return nil
+	}
+	if o.LeaseDuration <= o.RenewDeadline {
+		return fmt.Errorf("--leader-elect-lease-duration must be greater than --leader-elect-renew-deadline")
+	}
+	if o.RenewDeadline <= o.RetryPeriod {
+		return fmt.Errorf("--leader-elect-renew-deadline must be greater than --leader-elect-retry-period")
+	}
+	if o.RetryPeriod <= 0 {
+		return fmt.Errorf("--leader-elect-retry-period must be positive")
+	}
+	return nil
+}
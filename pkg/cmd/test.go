@@ -0,0 +1,209 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/cuebernetes/cuebectl/pkg/apply"
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/manifest"
+	"github.com/cuebernetes/cuebectl/pkg/policy"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
+)
+
+var (
+	testLong = templates.LongDesc(`
+		Test a cue package against golden files.
+
+		The package is applied to a simulated cluster, seeded with the objects in the fixtures directory (i.e. objects
+		the package references, or objects with the generated names or status it depends on). Custom resource
+		definitions in the fixtures register their kinds. Once every field is applied, the object for each field is
+		compared with the golden file <field>.yaml, leaving out fields populated by the cluster. With --update, the
+		golden files are rewritten instead.`)
+
+	testExample = templates.Examples(`
+		# Test a package, with fixtures in example/testdata/fixtures and golden files in example/testdata/golden
+		%[1]s test example
+
+		# Rewrite the golden files after an intended change
+		%[1]s test --update example`)
+)
+
+// TestOptions contains the input to the test command.
+type TestOptions struct {
+	CmdParent   string
+	Fixtures    string
+	Golden      string
+	Instance    string
+	Update      bool
+	Timeout     time.Duration
+	Policy      string
+	ShowSecrets bool
+
+	genericclioptions.IOStreams
+}
+
+// NewTestOptions returns initialized TestOptions
+func NewTestOptions(parent string, streams genericclioptions.IOStreams) *TestOptions {
+	return &TestOptions{
+		CmdParent: parent,
+		Timeout:   harness.DefaultTimeout,
+		IOStreams: streams,
+	}
+}
+
+// NewCmdTest creates a command object for "test"
+func NewCmdTest(parent string, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewTestOptions(parent, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "test DIR [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Test a cue package against golden files",
+		Long:                  testLong,
+		Example:               fmt.Sprintf(testExample, parent),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Complete(cmd, args))
+			cmdutil.CheckErr(o.Validate(cmd, args))
+			cmdutil.CheckErr(o.Run(cmd, args))
+		},
+	}
+
+	cmd.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for %s test", parent))
+	cmd.Flags().StringVar(&o.Fixtures, "fixtures", o.Fixtures, "directory of yaml or json objects to seed the cluster with (defaults to DIR/testdata/fixtures)")
+	cmd.Flags().StringVar(&o.Golden, "golden", o.Golden, "directory of golden files (defaults to DIR/testdata/golden)")
+	cmd.Flags().StringVar(&o.Instance, "instance", o.Instance, "name of the instance, used to label managed objects (defaults to the cue package name)")
+	cmd.Flags().BoolVar(&o.Update, "update", o.Update, "rewrite the golden files to match the applied objects")
+	cmd.Flags().StringVar(&o.Policy, "policy", o.Policy, "directory of a cue package with deny and warn constraints for objects, keyed by apiVersion/kind")
+	cmd.Flags().BoolVar(&o.ShowSecrets, "show-secrets", o.ShowSecrets, "show Secret data and fields marked with @cuebectl(sensitive) in diffs and errors")
+	cmd.Flags().DurationVar(&o.Timeout, "timeout", o.Timeout, "how long to wait for every field to be applied")
+
+	return cmd
+}
+
+// Complete takes the command arguments and infers any remaining options.
+func (o *TestOptions) Complete(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if o.Fixtures == "" {
+		o.Fixtures = filepath.Join(args[0], "testdata", "fixtures")
+	}
+	if o.Golden == "" {
+		o.Golden = filepath.Join(args[0], "testdata", "golden")
+	}
+	return nil
+}
+
+// Validate checks the set of flags provided by the user.
+func (o *TestOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("must supply exactly one directory with cue definitions")
+	}
+	if o.Timeout <= 0 {
+		return fmt.Errorf("--timeout must be positive")
+	}
+	return nil
+}
+
+// Run performs the test operation.
+func (o *TestOptions) Run(cmd *cobra.Command, args []string) error {
+	fixtures, err := o.fixtures()
+	if err != nil {
+		return err
+	}
+	h, err := harness.New(harness.CRDKinds(fixtures)...)
+	if err != nil {
+		return err
+	}
+	h.Timeout = o.Timeout
+	if err := h.Seed(fixtures...); err != nil {
+		return fmt.Errorf("could not seed fixtures: %v", err)
+	}
+
+	r, b, instance, err := apply.Load(args[0], nil)
+	if err != nil {
+		return err
+	}
+	h.Options.Name = o.Instance
+	h.Options.ShowSecrets = o.ShowSecrets
+	if h.Options.Name == "" {
+		h.Options.Name = apply.DefaultName(b)
+	}
+	if o.Policy != "" {
+		if h.Options.Policy, err = policy.Load(o.Policy); err != nil {
+			return fmt.Errorf("could not load policy: %v", err)
+		}
+	}
+	result, err := h.ApplyInstance(r, instance)
+	if err != nil {
+		return err
+	}
+	for _, path := range sortedPaths(result) {
+		if w := result.Outcomes[path].Warning; w != "" {
+			fmt.Fprintf(o.ErrOut, "%s: %s\n", path, w)
+		}
+	}
+
+	mismatches, err := result.Golden(o.Golden, o.Update)
+	if err != nil {
+		return err
+	}
+	if o.Update {
+		fmt.Fprintf(o.Out, "updated %d golden files in %s\n", applied(result), o.Golden)
+		return nil
+	}
+	for _, m := range mismatches {
+		name := m.Path
+		if name == "" {
+			name = "(no field)"
+		}
+		fmt.Fprintf(o.Out, "--- FAIL: %s (%s)\n%s\n", name, m.File, m.Diff)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of the objects don't match their golden files, rerun with --update if the changes are intended", len(mismatches))
+	}
+	fmt.Fprintf(o.Out, "ok\t%s\t%d objects\n", args[0], applied(result))
+	return nil
+}
+
+// fixtures reads the objects to seed the cluster with, if there are any
+func (o *TestOptions) fixtures() ([]*unstructured.Unstructured, error) {
+	if _, err := os.Stat(o.Fixtures); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return manifest.ReadFiles(o.Fixtures)
+}
+
+// sortedPaths returns the paths of the outcomes of result, sorted
+func sortedPaths(result *harness.Result) []string {
+	paths := make([]string, 0, len(result.Outcomes))
+	for path := range result.Outcomes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// applied counts the fields of result that have golden files
+func applied(result *harness.Result) int {
+	n := 0
+	for _, o := range result.Outcomes {
+		if o.Status == reconcile.Applied {
+			n++
+		}
+	}
+	return n
+}
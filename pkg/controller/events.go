@@ -0,0 +1,59 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package controller
+
+// Event is published by a CueInstanceController as it syncs the instance with the cluster. It is one of StateEvent,
+// ErrorEvent, WarningEvent or SyncedEvent.
+type Event interface {
+	isEvent()
+}
+
+// StateEvent is published when the state of the objects in the cluster that are tracked by the instance changes
+type StateEvent struct {
+	State ClusterState
+
+	// Converged is set if every label of the instance has been applied, i.e. there is an object (or list) in the
+	// state for each of them
+	Converged bool
+}
+
+// ErrorEvent is published when a label can't be synced. The label is retried.
+type ErrorEvent struct {
+	Label string
+	Err   error
+}
+
+// WarningEvent is published when the object for a label is applied despite a problem, i.e. a policy warning. It is
+// published again only if the warning changes.
+type WarningEvent struct {
+	Label   string
+	Message string
+}
+
+// SyncedEvent is published when a label is synced without error, so that earlier errors for it can be cleared
+type SyncedEvent struct {
+	Label string
+}
+
+func (StateEvent) isEvent()   {}
+func (ErrorEvent) isEvent()   {}
+func (WarningEvent) isEvent() {}
+func (SyncedEvent) isEvent()  {}
+
+// EventBufferSize is the recommended capacity of the channel passed to Start, so that bursts of events don't block
+// syncing
+const EventBufferSize = 64
+
+// Subscriber handles the events published by a CueInstanceController. Handle is called for each event, in order,
+// from a single goroutine; returning an error stops the run.
+type Subscriber interface {
+	Handle(Event) error
+}
+
+// SubscriberFunc adapts a function to a Subscriber
+type SubscriberFunc func(Event) error
+
+func (f SubscriberFunc) Handle(e Event) error {
+	return f(e)
+}
@@ -5,19 +5,33 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"cuelang.org/go/cue"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
 	"github.com/cuebernetes/cuebectl/pkg/cache"
 	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/events"
 	"github.com/cuebernetes/cuebectl/pkg/identity"
+	"github.com/cuebernetes/cuebectl/pkg/metrics"
+	"github.com/cuebernetes/cuebectl/pkg/policy"
+	"github.com/cuebernetes/cuebectl/pkg/redact"
 	"github.com/cuebernetes/cuebectl/pkg/tracker"
 	"github.com/cuebernetes/cuebectl/pkg/unifier"
 )
@@ -32,56 +46,306 @@ func (c ClusterState) Locators() []identity.Locator {
 	return locators
 }
 
+// ByPath returns the objects in the state keyed by the path of their field, joined with "/"
+func (c ClusterState) ByPath() map[string]*unstructured.Unstructured {
+	byPath := make(map[string]*unstructured.Unstructured, len(c))
+	for l, u := range c {
+		byPath[strings.Join(l.Path, "/")] = u
+	}
+	return byPath
+}
+
+// Options configure a CueInstanceController
+type Options struct {
+	// Name identifies the instance. Objects created or adopted by the controller are labelled with it.
+	Name string
+
+	// Adopt allows existing objects that aren't managed by any instance to be adopted.
+	Adopt bool
+
+	// ForceAdopt allows existing objects that are managed by another instance to be adopted.
+	ForceAdopt bool
+
+	// Prune deletes the objects of fields that are no longer in the instance. Otherwise, they are left in the cluster
+	// and no longer watched.
+	Prune bool
+
+	// Workers is the number of labels, and of changes to cluster objects, that are processed concurrently. It
+	// defaults to 1.
+	Workers int
+
+	// Recorder records events for changes to managed objects. If nil, no events are recorded.
+	Recorder *events.Recorder
+
+	// Ensurer constructs the ensurer that writes objects. It defaults to ensure.DynamicFactory.
+	Ensurer ensure.Factory
+
+	// InformerFactory constructs the informers that watch the cluster. It defaults to
+	// cache.DefaultScopedDynamicInformerFactory.
+	InformerFactory cache.ScopedDynamicInformerFactory
+
+	// Clusters are the clusters other than the default cluster, keyed by the kube context that fields name with
+	// @cuebectl(context=...). Events are only recorded for objects in the default cluster.
+	Clusters map[string]Cluster
+
+	// Impersonate returns a client for the default cluster that impersonates a user, for fields with
+	// @cuebectl(serviceAccount=...). If nil, those fields fail.
+	Impersonate ensure.Impersonator
+
+	// Namespace restricts the objects that are applied and referenced to one namespace, if set. Cluster-scoped objects,
+	// and objects in other namespaces, fail.
+	Namespace string
+
+	// ServiceAccountNamespace restricts the service accounts that fields may be applied as, with
+	// @cuebectl(serviceAccount=...), to one namespace, if set
+	ServiceAccountNamespace string
+
+	// Policy constrains objects before they are applied. Objects that violate deny constraints aren't applied, and
+	// warnings are published as WarningEvents.
+	Policy *policy.Policy
+
+	// ShowSecrets leaves Secret payloads, and fields marked with @cuebectl(sensitive), unmasked in published errors
+	// and warnings, events and logs
+	ShowSecrets bool
+}
+
+// Cluster is a cluster that objects can be applied to
+type Cluster struct {
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+
+	// Impersonate returns a client for the cluster that impersonates a user, and may be nil
+	Impersonate ensure.Impersonator
+}
+
 type CueInstanceController struct {
 	clusterQueue, cueQueue workqueue.RateLimitingInterface
 	informerCache          cache.Interface
 	tracker                tracker.Interface
 	unifier                unifier.Interface
 	resourceVersions       *lastResourceVersions
+	options                Options
+
+	// warnings are the last policy warnings published for each label
+	warnings sync.Map
+
+	// notConcrete are the last NotConcrete event messages recorded for each label, until it syncs
+	notConcrete sync.Map
+
+	// redactor masks sensitive values in output, and is nil if secrets are shown
+	redactor *redact.Redactor
+
+	// mappers are the RESTMappers of the clusters, keyed by kube context
+	mappers map[string]meta.RESTMapper
+
+	// total is the number of labels in the instance, as of the last fill
+	total int32
+
+	// filled is set once the instance has been queued without error
+	filled int32
+
+	// processed are the labels that have synced or failed at least once, and processedCount is their number
+	processed      sync.Map
+	processedCount int32
+
+	// workers are the goroutines started by Start that process the queues
+	workers sync.WaitGroup
+
+	// events receives the events published by the controller, until stopc is closed
+	events chan<- Event
+	stopc  <-chan struct{}
 }
 
-func NewCueInstanceController(client dynamic.Interface, mapper meta.RESTMapper, runtime *cue.Runtime, instance *cue.Instance) *CueInstanceController {
-	informerCache := cache.NewDynamicInformerCache(client)
+func NewCueInstanceController(client dynamic.Interface, mapper meta.RESTMapper, runtime *cue.Runtime, instance *cue.Instance, options Options) (*CueInstanceController, error) {
+	if options.Ensurer == nil {
+		options.Ensurer = ensure.DynamicFactory
+	}
+	if options.InformerFactory == nil {
+		options.InformerFactory = cache.DefaultScopedDynamicInformerFactory
+	}
+
+	clusterQueue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), options.Name+"_cluster")
+	managedSelector := labels.SelectorFromSet(labels.Set{ensure.InstanceLabel: options.Name}).String()
+
+	// each cluster has its own cache and ensurer, and changes to objects in any cluster are queued together
+	clusters := map[string]Cluster{"": {Client: client, Mapper: mapper, Impersonate: options.Impersonate}}
+	for context, cluster := range options.Clusters {
+		if context != "" {
+			clusters[context] = cluster
+		}
+	}
+	caches := map[string]cache.Interface{}
+	ensurers := map[string]ensure.Interface{}
+	mappers := map[string]meta.RESTMapper{}
+	for context, cluster := range clusters {
+		informerCache := cache.NewDynamicInformerCache(cluster.Client, managedSelector, clusterQueue)
+		config := ensure.Config{
+			Client:   cluster.Client,
+			Mapper:   cluster.Mapper,
+			Cache:    informerCache,
+			Instance: options.Name,
+		}
+		if context == "" {
+			config.Recorder = options.Recorder
+		}
+		ensurer, err := ensure.NewImpersonatingEnsurer(options.Ensurer, config, cluster.Impersonate)
+		if err != nil {
+			return nil, err
+		}
+		caches[context] = informerCache
+		ensurers[context] = ensurer
+		mappers[context] = cluster.Mapper
+	}
+	informerCache := cache.NewMultiClusterCache(caches)
+	var redactor *redact.Redactor
+	if !options.ShowSecrets {
+		redactor = redact.New(instance.Value())
+	}
+
 	return &CueInstanceController{
-		clusterQueue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		cueQueue:         workqueue.NewRateLimitingQueue(workqueue.DefaultItemBasedRateLimiter()),
-		tracker:          tracker.NewLocationTracker(ensure.NewDynamicUnstructuredEnsurer(client, mapper, informerCache)),
+		clusterQueue:     clusterQueue,
+		cueQueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultItemBasedRateLimiter(), options.Name+"_cue"),
+		tracker:          tracker.NewLocationTracker(ensure.NewMultiClusterEnsurer(ensurers)),
 		unifier:          unifier.NewClusterUnifier(runtime, instance, informerCache),
 		informerCache:    informerCache,
 		resourceVersions: NewLastResourceVersions(),
-	}
+		mappers:          mappers,
+		options:          options,
+		redactor:         redactor,
+	}, nil
 }
 
-func (c *CueInstanceController) Start(ctx context.Context, stateChan chan ClusterState, errChan chan error) (count int, err error) {
-	count, err = c.unifier.Fill(c.cueQueue)
-	go c.processClusterStateQueue(stateChan)
-	go c.processCueQueue(ctx, errChan)
+// Start queues the instance and starts processing, publishing events to events. Processing stops, and the informers
+// started by the controller are stopped, when ctx is done. Publishing blocks until events are received, so events
+// should be buffered (see EventBufferSize) and received until ctx is done. Wait blocks until processing has stopped.
+func (c *CueInstanceController) Start(ctx context.Context, events chan<- Event) (count int, err error) {
+	c.events = events
+	c.stopc = ctx.Done()
+	count, err = c.fill()
+	workers := c.options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	c.workers.Add(2 * workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer c.workers.Done()
+			c.processClusterStateQueue()
+		}()
+		go func() {
+			defer c.workers.Done()
+			c.processCueQueue()
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		c.cueQueue.ShutDown()
+		c.clusterQueue.ShutDown()
+	}()
 	return
 }
 
-func (c *CueInstanceController) syncUnstructured(u *identity.LocatedUnstructured, stateChan chan ClusterState) {
+// Wait blocks until the workers started by Start have exited, which they do once the context passed to Start is done
+// and the labels being synced have finished
+func (c *CueInstanceController) Wait() {
+	c.workers.Wait()
+}
+
+// Total returns the number of labels in the instance. It can change when labels are generated from cluster state.
+func (c *CueInstanceController) Total() int {
+	return int(atomic.LoadInt32(&c.total))
+}
+
+// Redactor returns the redactor that masks sensitive values of the instance in output, or nil if secrets are shown
+func (c *CueInstanceController) Redactor() *redact.Redactor {
+	return c.redactor
+}
+
+// fill queues every label in the instance
+func (c *CueInstanceController) fill() (int, error) {
+	total, err := c.unifier.Fill(c.informerCache.FromCluster(c.tracker.Locators()), c.cueQueue)
+	if err != nil {
+		return total, err
+	}
+	atomic.StoreInt32(&c.total, int32(total))
+	atomic.StoreInt32(&c.filled, 1)
+	return total, nil
+}
+
+// HasSynced returns true once the instance has been queued, every label has synced or failed at least once, and the
+// informers watching the cluster have synced
+func (c *CueInstanceController) HasSynced() bool {
+	if atomic.LoadInt32(&c.filled) == 0 || atomic.LoadInt32(&c.processedCount) < atomic.LoadInt32(&c.total) {
+		return false
+	}
+	return c.informerCache.HasSynced()
+}
+
+func (c *CueInstanceController) syncUnstructured(u *identity.LocatedUnstructured) {
+	c.redactor.Observe(strings.Join(u.Locator.Path, "/"), u.Unstructured)
 	if rv, ok := c.resourceVersions.Get(strings.Join(u.Locator.Path, "/")); ok && rv == u.GetResourceVersion() {
 		klog.V(2).Infof("cache hasn't yet caught up to recent changes")
 		return
 	}
 
+	if u.Locator.ReadOnly {
+		c.refresh()
+		return
+	}
+
 	// requeue the label associated with the object in the cue instance
 	c.cueQueue.Add(strings.Join(u.Locator.Path, "/"))
 
 	// send back current cluster state
-	stateChan <- c.informerCache.FromCluster(c.tracker.Locators())
+	state := c.informerCache.FromCluster(c.tracker.Locators())
+	c.publish(StateEvent{State: state, Converged: len(state) >= c.Total()})
+}
+
+// refresh requeues every label, since any label may depend on a referenced object or be generated from a list, and
+// publishes the current cluster state
+func (c *CueInstanceController) refresh() {
+	if _, err := c.fill(); err != nil {
+		klog.V(1).Error(c.redactor.Error(err), "could not requeue instance")
+	}
+	state := c.informerCache.FromCluster(c.tracker.Locators())
+	c.publish(StateEvent{State: state, Converged: len(state) >= c.Total()})
 }
 
-func (c *CueInstanceController) syncCueInstance(label string, errChan chan error, stopc <-chan struct{}) {
+func (c *CueInstanceController) syncCueInstance(label string) {
+	attrs := c.unifier.Attributes(label)
+	if attrs.Flag(attributes.Ref) || attrs.Flag(attributes.List) {
+		c.syncReference(label, attrs.Flag(attributes.List))
+		return
+	}
+
+	start := time.Now()
+
 	// unify cue instance with current cluster state and lookup value at `label`
 	obj, err := c.unifier.Lookup(c.informerCache.FromCluster(c.tracker.Locators()), label)
+	if errors.Is(err, unifier.ErrNotExist) {
+		klog.V(2).Infof("%s is no longer in the instance", label)
+		if !c.options.Prune {
+			c.tracker.Untrack(label)
+		} else if err := c.tracker.Delete(label); err != nil {
+			c.report(label, err)
+			c.cueQueue.AddRateLimited(label)
+			return
+		}
+		c.informerCache.Unwatch(label)
+		c.synced(label)
+		return
+	}
 	if err != nil {
-		errChan <- err
-		klog.V(1).Error(err, "could not lookup")
+		c.observeLookupError(label, err)
+		metrics.ObserveSync(label, schema.GroupVersionKind{}, start, err)
+		c.report(label, err)
+		klog.V(1).Error(c.redactor.Error(err), "could not lookup")
 		c.cueQueue.AddRateLimited(label)
 		return
 	}
 
+	c.redactor.Observe(label, obj)
+
 	rv, ok := c.resourceVersions.Get(label)
 	objrv := obj.GetResourceVersion()
 	if ok && rv == objrv {
@@ -90,27 +354,292 @@ func (c *CueInstanceController) syncCueInstance(label string, errChan chan error
 	}
 
 	// sync value at `label` with the cluster
-	oldrv, locator, err := c.tracker.Sync(obj, label)
+	options := c.ensureOptions(attrs)
+	options.Source = c.unifier.Source(label)
+	if err := c.checkNamespace(options.Context, obj.GroupVersionKind(), obj.GetNamespace()); err != nil {
+		c.report(label, err)
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	if options.User, err = c.serviceAccountUser(attrs, obj); err != nil {
+		c.report(label, err)
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	if err := c.checkPolicy(label, obj); err != nil {
+		metrics.ObserveSync(label, obj.GroupVersionKind(), start, err)
+		c.report(label, err)
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	oldrv, locator, err := c.tracker.Sync(obj, options, label)
+	metrics.ObserveSync(label, obj.GroupVersionKind(), start, err)
 	if err != nil {
-		errChan <- err
-		klog.V(1).Error(err, "could not sync")
+		c.report(label, err)
+		klog.V(1).Error(c.redactor.Error(err), "could not sync")
 		c.cueQueue.AddRateLimited(label)
 		return
 	}
 	c.resourceVersions.Set(label, oldrv)
 
-	// start up informers for newly synced NGVRs
-	inf := c.informerCache.Get(locator.NamespacedGroupVersionResource)
-	if inf == nil {
-		inf = c.informerCache.Add(locator.NamespacedGroupVersionResource, cache.DefaultNamespacedDynamicInformerFactory, stopc)
+	// watch the managed objects of the synced NGVR; changes to the synced object are queued
+	c.informerCache.Watch(locator, c.options.InformerFactory, c.stopc)
+
+	c.synced(label)
+}
+
+// syncReference locates the object (or list of objects) referenced at `label` and tracks it, so that it is filled
+// into the instance. Referenced objects are never written.
+func (c *CueInstanceController) syncReference(label string, list bool) {
+	ref, err := c.unifier.Reference(c.informerCache.FromCluster(c.tracker.Locators()), label)
+	if err != nil {
+		c.observeLookupError(label, err)
+		c.report(label, err)
+		klog.V(1).Error(c.redactor.Error(err), "could not lookup reference")
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+
+	context, _ := c.unifier.Attributes(label).Get(attributes.Context)
+	mapper, ok := c.mappers[context]
+	if !ok {
+		c.report(label, fmt.Errorf("unknown kube context %q", context))
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	mapping, err := mapper.RESTMapping(ref.GroupKind(), ref.Version)
+	if err != nil {
+		c.report(label, err)
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	if err := c.checkNamespace(context, ref.GroupVersionKind, ref.Namespace); err != nil {
+		c.report(label, err)
+		c.cueQueue.AddRateLimited(label)
+		return
+	}
+	ngvr := identity.NamespacedGroupVersionResource{GroupVersionResource: mapping.Resource, Namespace: ref.Namespace}
+	locator := &identity.Locator{NamespacedGroupVersionResource: ngvr, Name: ref.Name, Path: []string{label}, ReadOnly: true, List: list, Selector: ref.Selector, Context: context}
+
+	// watch only the referenced object, or the objects matching the selector
+	inf := c.informerCache.Watch(locator, c.options.InformerFactory, c.stopc)
+	if list || ref.Name == "" {
+		// wait for the informer to sync so that lists are complete and selectors can be resolved
+		if !inf.Informer().HasSynced() {
+			c.cueQueue.AddRateLimited(label)
+			return
+		}
+	}
+	if !list && ref.Name == "" {
+		if locator.Name, err = findBySelector(inf, ref); err != nil {
+			c.report(label, err)
+			c.cueQueue.AddRateLimited(label)
+			return
+		}
+	}
+
+	if c.tracker.Track(locator) {
+		// watch again, so that changes are queued for the resolved name
+		c.informerCache.Watch(locator, c.options.InformerFactory, c.stopc)
+		// objects the informer delivered before the locator was tracked, and empty lists, aren't queued again
+		c.refresh()
+	}
+	if !list {
+		// report references that don't exist, once the informer has listed the cluster
+		if !inf.Informer().HasSynced() {
+			c.cueQueue.AddRateLimited(label)
+			return
+		}
+		if err := findByName(inf, locator, ref.Kind); err != nil {
+			c.report(label, err)
+			c.cueQueue.AddRateLimited(label)
+			return
+		}
+	}
+	c.synced(label)
+}
+
+// findByName returns an error if the object of kind identified by locator isn't in the informer
+func findByName(inf informers.GenericInformer, locator *identity.Locator, kind string) error {
+	var err error
+	if locator.Namespace != "" {
+		_, err = inf.Lister().ByNamespace(locator.Namespace).Get(locator.Name)
+	} else {
+		_, err = inf.Lister().Get(locator.Name)
+	}
+	if apierrors.IsNotFound(err) {
+		name := locator.Name
+		if locator.Namespace != "" {
+			name = locator.Namespace + "/" + name
+		}
+		return fmt.Errorf("referenced %s %s not found", kind, name)
+	}
+	return err
+}
+
+// findBySelector returns the name of the single object in the informer that matches the reference's selector
+func findBySelector(inf informers.GenericInformer, ref *identity.Reference) (string, error) {
+	selector, err := labels.Parse(ref.Selector)
+	if err != nil {
+		return "", err
+	}
+	var objs []runtime.Object
+	if ref.Namespace != "" {
+		objs, err = inf.Lister().ByNamespace(ref.Namespace).List(selector)
+	} else {
+		objs, err = inf.Lister().List(selector)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(objs) != 1 {
+		return "", fmt.Errorf("selector %q matches %d %s objects, expected exactly one", ref.Selector, len(objs), ref.Kind)
+	}
+	u, ok := objs[0].(*unstructured.Unstructured)
+	if !ok {
+		return "", fmt.Errorf("unexpected object type %T", objs[0])
+	}
+	return u.GetName(), nil
+}
+
+// ensureOptions combines the controller options with the attributes of a field
+func (c *CueInstanceController) ensureOptions(attrs attributes.Attributes) ensure.Options {
+	options := ensure.Options{
+		Adopt:      c.options.Adopt || attrs.Flag(attributes.Adopt),
+		ForceAdopt: c.options.ForceAdopt || (attrs.Flag(attributes.Adopt) && attrs.Flag(attributes.Force)),
+	}
+	if selector, ok := attrs.Get(attributes.Selector); ok {
+		options.Adopt = true
+		options.Selector = selector
+	}
+	options.Context, _ = attrs.Get(attributes.Context)
+	return options
+}
+
+// checkNamespace returns an error if the controller is restricted to a namespace, and an object of kind gvk in
+// namespace is outside of it
+func (c *CueInstanceController) checkNamespace(context string, gvk schema.GroupVersionKind, namespace string) error {
+	if c.options.Namespace == "" {
+		return nil
+	}
+	mapper, ok := c.mappers[context]
+	if !ok {
+		return fmt.Errorf("unknown kube context %q", context)
+	}
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return fmt.Errorf("%s is cluster-scoped, and only objects in namespace %q are allowed", gvk.Kind, c.options.Namespace)
+	}
+	if namespace != c.options.Namespace {
+		return fmt.Errorf("%s must be in namespace %q, not %q", gvk.Kind, c.options.Namespace, namespace)
 	}
-	// add an eventhandler that only reacts to the synced object
-	inf.Informer().AddEventHandler(locator.EventHandler(c.clusterQueue))
+	return nil
+}
 
+// checkPolicy returns an error if obj violates a deny constraint of the policy, and publishes the warnings for obj if
+// they changed since they were last published
+func (c *CueInstanceController) checkPolicy(label string, obj *unstructured.Unstructured) error {
+	violations := c.options.Policy.Check(obj)
+	if err := policy.Denied(obj, violations); err != nil {
+		return err
+	}
+	var warnings []string
+	for _, v := range violations {
+		warnings = append(warnings, v.String())
+	}
+	message := c.redactor.String(strings.Join(warnings, "; "))
+	if last, ok := c.warnings.Load(label); (ok && last == message) || (!ok && message == "") {
+		return nil
+	}
+	c.warnings.Store(label, message)
+	if message != "" {
+		klog.V(1).Infof("policy warning for %s: %s", label, message)
+		c.publish(WarningEvent{Label: label, Message: message})
+	}
+	return nil
+}
+
+// serviceAccountUser returns the user to impersonate for a field with @cuebectl(serviceAccount=...), or "" if the
+// field doesn't name a service account
+func (c *CueInstanceController) serviceAccountUser(attrs attributes.Attributes, obj *unstructured.Unstructured) (string, error) {
+	sa, ok := attrs.Get(attributes.ServiceAccount)
+	if !ok {
+		return "", nil
+	}
+	namespace, name := obj.GetNamespace(), sa
+	if i := strings.Index(sa, "/"); i >= 0 {
+		namespace, name = sa[:i], sa[i+1:]
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid service account %q: must be name or namespace/name", sa)
+	}
+	if namespace == "" {
+		return "", fmt.Errorf("service account %q needs a namespace, i.e. namespace/%s, for an object without one", sa, name)
+	}
+	if allowed := c.options.ServiceAccountNamespace; allowed != "" && namespace != allowed {
+		return "", fmt.Errorf("service account %q must be in namespace %q", sa, allowed)
+	}
+	return ensure.ServiceAccountUser(namespace, name), nil
+}
+
+// observeLookupError counts and records lookups that failed because the value at label isn't concrete yet
+func (c *CueInstanceController) observeLookupError(label string, err error) {
+	var notConcrete *unifier.NotConcreteError
+	if !errors.As(err, &notConcrete) {
+		return
+	}
+	metrics.NotConcrete.WithLabelValues(label).Inc()
+
+	// the field is retried until it is concrete; only record the event when the reason changes
+	message := c.redactor.String(notConcrete.Message)
+	if last, ok := c.notConcrete.Load(label); ok && last == message {
+		return
+	}
+	c.notConcrete.Store(label, message)
+
+	// record the event on the object applied for the label, if there is one
+	var obj runtime.Object
+	for l, u := range c.informerCache.FromCluster(c.tracker.Locators()) {
+		if !l.ReadOnly && l.Context == "" && strings.Join(l.Path, "/") == label {
+			obj = u
+		}
+	}
+	c.options.Recorder.Warning(obj, []string{label}, events.NotConcrete, "%s", message)
+}
+
+// synced stops retrying label, which synced without error, and publishes that it synced
+func (c *CueInstanceController) synced(label string) {
 	c.cueQueue.Forget(label)
+	c.notConcrete.Delete(label)
+	c.process(label)
+	c.publish(SyncedEvent{Label: label})
+}
+
+// report publishes an error syncing label
+func (c *CueInstanceController) report(label string, err error) {
+	c.process(label)
+	c.publish(ErrorEvent{Label: label, Err: c.redactor.Error(err)})
+}
+
+// process records that label has synced or failed at least once
+func (c *CueInstanceController) process(label string) {
+	if _, loaded := c.processed.LoadOrStore(label, struct{}{}); !loaded {
+		atomic.AddInt32(&c.processedCount, 1)
+	}
+}
+
+// publish sends e to the subscriber, unless the controller is stopped first
+func (c *CueInstanceController) publish(e Event) {
+	select {
+	case c.events <- e:
+	case <-c.stopc:
+	}
 }
 
-func (c *CueInstanceController) processClusterStateQueue(stateChan chan ClusterState) {
+func (c *CueInstanceController) processClusterStateQueue() {
 	for {
 		if c.clusterQueue.ShuttingDown() {
 			return
@@ -128,12 +657,12 @@ func (c *CueInstanceController) processClusterStateQueue(stateChan chan ClusterS
 				klog.V(2).Infof("expected object of type LocatedUnstructured, got: %#v\n", u)
 				return
 			}
-			c.syncUnstructured(u, stateChan)
+			c.syncUnstructured(u)
 		}()
 	}
 }
 
-func (c *CueInstanceController) processCueQueue(ctx context.Context, errChan chan error) {
+func (c *CueInstanceController) processCueQueue() {
 	for {
 		if c.cueQueue.ShuttingDown() {
 			break
@@ -152,7 +681,7 @@ func (c *CueInstanceController) processCueQueue(ctx context.Context, errChan cha
 				c.cueQueue.Forget(label)
 				return
 			}
-			c.syncCueInstance(label, errChan, ctx.Done())
+			c.syncCueInstance(label)
 		}()
 	}
 }
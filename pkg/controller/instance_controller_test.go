@@ -0,0 +1,407 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package controller_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+)
+
+func compile(t *testing.T, src string) (*cue.Runtime, *cue.Instance) {
+	t.Helper()
+	r := &cue.Runtime{}
+	instance, err := r.Compile("test.cue", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, instance
+}
+
+func newHarness(t *testing.T) *harness.Harness {
+	t.Helper()
+	h, err := harness.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Timeout = 10 * time.Second
+	return h
+}
+
+func apply(t *testing.T, h *harness.Harness, src string) *harness.Result {
+	t.Helper()
+	result, err := h.ApplyInstance(compile(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.AssertApplied(t)
+	return result
+}
+
+func TestGenerateNameIsKept(t *testing.T) {
+	h := newHarness(t)
+	src := `
+sa: {
+	apiVersion: "v1"
+	kind:       "ServiceAccount"
+	metadata: {generateName: "sa-", namespace: "default"}
+}
+binding: {
+	apiVersion: "rbac.authorization.k8s.io/v1"
+	kind:       "RoleBinding"
+	metadata: {name: "binding", namespace: "default"}
+	roleRef: {apiGroup: "rbac.authorization.k8s.io", kind: "Role", name: "reader"}
+	subjects: [{kind: "ServiceAccount", name: sa.metadata.name, namespace: "default"}]
+}
+`
+	first := apply(t, h, src)
+	name, err := first.Field("sa", "metadata", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.AssertField(t, []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": name, "namespace": "default"}}, "binding", "subjects")
+
+	// applying the instance again finds the object created for the field, instead of generating another name
+	second := apply(t, h, src)
+	second.AssertField(t, name, "sa", "metadata", "name")
+}
+
+func TestReferenceUpdates(t *testing.T) {
+	h := newHarness(t)
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "token", "namespace": "kube-system", "uid": "1234"},
+		"type":       "Opaque",
+	}}
+	if err := h.Seed(existing); err != nil {
+		t.Fatal(err)
+	}
+	result := apply(t, h, `
+token: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata: {name: "token", namespace: "kube-system", uid: string}
+} @cuebectl(ref)
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: tokenUID: token.metadata.uid
+}
+`)
+	result.AssertStatus(t, reconcile.Referenced, "token")
+	result.AssertField(t, "1234", "config", "data", "tokenUID")
+}
+
+func TestList(t *testing.T) {
+	h := newHarness(t)
+	for _, name := range []string{"a", "b", "c"} {
+		team := "x"
+		if name == "c" {
+			team = "y"
+		}
+		if err := h.Seed(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": name, "labels": map[string]interface{}{"team": team}},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := apply(t, h, `
+namespaces: {
+	apiVersion: "v1"
+	kind:       "Namespace"
+	items: [...{metadata: name: string}]
+} @cuebectl(list, selector="team=x")
+
+// lists without items converge too
+none: {
+	apiVersion: "v1"
+	kind:       "Namespace"
+	items: []
+} @cuebectl(list, selector="team=z")
+
+for ns in namespaces.items {
+	"config-\(ns.metadata.name)": {
+		apiVersion: "v1"
+		kind:       "ConfigMap"
+		metadata: {name: "config", namespace: ns.metadata.name}
+	}
+}
+`)
+	result.AssertStatus(t, reconcile.Listed, "namespaces")
+	result.AssertStatus(t, reconcile.Listed, "none")
+	result.AssertStatus(t, reconcile.Applied, "config-a")
+	result.AssertStatus(t, reconcile.Applied, "config-b")
+	if _, ok := result.Outcomes["config-c"]; ok {
+		t.Errorf("config-c was applied for a namespace that doesn't match the selector")
+	}
+}
+
+func TestStatusMutator(t *testing.T) {
+	h := newHarness(t)
+	h.Mutate(schema.GroupKind{Group: "apps", Kind: "Deployment"}, func(u *unstructured.Unstructured) {
+		replicas, _, _ := unstructured.NestedFieldCopy(u.Object, "spec", "replicas")
+		_ = unstructured.SetNestedField(u.Object, replicas, "status", "readyReplicas")
+	})
+	result := apply(t, h, `
+app: {
+	apiVersion: "apps/v1"
+	kind:       "Deployment"
+	metadata: {name: "app", namespace: "default"}
+	spec: replicas: 2
+	status?: readyReplicas: int
+}
+ready: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "ready", namespace: "default"}
+	data: replicas: "\(app.status.readyReplicas)"
+}
+`)
+	result.AssertField(t, "2", "ready", "data", "replicas")
+}
+
+// run starts a controller for src against client, and returns the events published until every label has synced or
+// failed
+func run(t *testing.T, client *simulate.Cluster, src string, options controller.Options) (errs map[string]error, state controller.ClusterState) {
+	t.Helper()
+	r, instance := compile(t, src)
+	c, err := controller.NewCueInstanceController(client, harness.NewRESTMapper(), r, instance, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan controller.Event, controller.EventBufferSize)
+	total, err := c.Start(ctx, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs = map[string]error{}
+	synced := map[string]bool{}
+	for len(errs)+len(synced) < total {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case controller.StateEvent:
+				state = e.State
+			case controller.ErrorEvent:
+				errs[e.Label] = e.Err
+			case controller.SyncedEvent:
+				synced[e.Label] = true
+				delete(errs, e.Label)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out, synced %v, failed %v", synced, errs)
+		}
+	}
+	return errs, state
+}
+
+func TestNamespace(t *testing.T) {
+	client, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, _ := run(t, client, `
+inside: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "inside", namespace: "tenant"}
+}
+outside: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "outside", namespace: "other"}
+}
+clusterScoped: {
+	apiVersion: "rbac.authorization.k8s.io/v1"
+	kind:       "ClusterRole"
+	metadata: name: "role"
+}
+reference: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata: {name: "token", namespace: "kube-system"}
+} @cuebectl(ref)
+`, controller.Options{Name: "test", Namespace: "tenant"})
+
+	if err, ok := errs["inside"]; ok {
+		t.Errorf("inside: unexpected error %v", err)
+	}
+	for label, want := range map[string]string{
+		"outside":       `must be in namespace "tenant"`,
+		"clusterScoped": "cluster-scoped",
+		"reference":     `must be in namespace "tenant"`,
+	} {
+		if err, ok := errs[label]; !ok || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", label, err, want)
+		}
+	}
+}
+
+func TestServiceAccountNamespace(t *testing.T) {
+	client, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := controller.Options{
+		Name:                    "test",
+		ServiceAccountNamespace: "tenant",
+		Impersonate:             func(string) (dynamic.Interface, error) { return client, nil },
+	}
+	errs, state := run(t, client, `
+own: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "own", namespace: "tenant"}
+} @cuebectl(serviceAccount="deployer")
+other: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "other", namespace: "tenant"}
+} @cuebectl(serviceAccount="kube-system/admin")
+`, options)
+
+	if err, ok := errs["own"]; ok {
+		t.Errorf("own: unexpected error %v", err)
+	}
+	if err, ok := errs["other"]; !ok || !strings.Contains(err.Error(), `must be in namespace "tenant"`) {
+		t.Errorf("other: got error %v, want the service account to be rejected", err)
+	}
+	for l := range state {
+		if strings.Join(l.Path, "/") == "other" {
+			t.Errorf("other was applied")
+		}
+	}
+}
+
+func TestNotConcreteRecordedOnce(t *testing.T) {
+	client, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, instance := compile(t, `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: key: string
+}
+`)
+	recorder := record.NewFakeRecorder(100)
+	inventory := &unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}}
+	c, err := controller.NewCueInstanceController(client, harness.NewRESTMapper(), r, instance, controller.Options{
+		Name:     "test",
+		Recorder: events.NewRecorder(recorder, inventory),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	published := make(chan controller.Event, controller.EventBufferSize)
+	if _, err := c.Start(ctx, published); err != nil {
+		t.Fatal(err)
+	}
+
+	// the field is retried until the context is done
+	errs := 0
+	for done := false; !done; {
+		select {
+		case e := <-published:
+			if _, ok := e.(controller.ErrorEvent); ok {
+				errs++
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	if errs < 2 {
+		t.Fatalf("got %d errors, want the field to be retried", errs)
+	}
+	if n := len(recorder.Events); n != 1 {
+		t.Errorf("got %d NotConcrete events for %d retries with the same message, want 1", n, errs)
+	}
+}
+
+func TestReferenceNotFound(t *testing.T) {
+	client, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, _ := run(t, client, `
+token: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata: {name: "missing", namespace: "kube-system"}
+} @cuebectl(ref)
+`, controller.Options{Name: "test"})
+
+	want := "referenced Secret kube-system/missing not found"
+	if err, ok := errs["token"]; !ok || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestHasSynced(t *testing.T) {
+	client, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, instance := compile(t, `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+}
+`)
+	c, err := controller.NewCueInstanceController(client, harness.NewRESTMapper(), r, instance, controller.Options{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HasSynced() {
+		t.Fatal("controller has synced before it was started")
+	}
+	defer c.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	published := make(chan controller.Event, controller.EventBufferSize)
+	if _, err := c.Start(ctx, published); err != nil {
+		t.Fatal(err)
+	}
+	for synced := false; !synced; {
+		select {
+		case e := <-published:
+			_, synced = e.(controller.SyncedEvent)
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for config to sync")
+		}
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return c.HasSynced(), nil
+	}); err != nil {
+		t.Error("controller has not synced after every label synced")
+	}
+}
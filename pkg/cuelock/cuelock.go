@@ -0,0 +1,23 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package cuelock serializes the use of cue across the process. Every cue.Runtime shares a global index of
+// instances, which cue doesn't lock, so building, compiling or filling an instance races with any other use of cue,
+// even of another runtime.
+package cuelock
+
+import "sync"
+
+var mu sync.RWMutex
+
+// Lock is held while instances are built, compiled, or filled
+func Lock() { mu.Lock() }
+
+// Unlock releases Lock
+func Unlock() { mu.Unlock() }
+
+// RLock is held while values of built instances are read, which can happen concurrently
+func RLock() { mu.RLock() }
+
+// RUnlock releases RLock
+func RUnlock() { mu.RUnlock() }
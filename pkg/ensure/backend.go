@@ -0,0 +1,149 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cuebernetes/cuebectl/pkg/events"
+)
+
+// Cluster is the cluster that a backend ensures objects in
+type Cluster int
+
+const (
+	// LiveCluster backends write to the cluster
+	LiveCluster Cluster = iota
+
+	// SimulatedCluster backends write to an in-memory copy of the cluster, which objects are read into as needed
+	SimulatedCluster
+
+	// EmptyCluster backends write to an in-memory cluster that starts out empty
+	EmptyCluster
+)
+
+// Target configures backends that write somewhere other than the cluster
+type Target struct {
+	// Dir is the directory that the yaml backend writes objects to
+	Dir string
+
+	// Out is where the dry-run backend reports changes
+	Out io.Writer
+}
+
+// Backend is a registered way of ensuring objects
+type Backend struct {
+	Name        string
+	Description string
+
+	// Cluster is the cluster that the backend's ensurers must be given, and that the controller must watch
+	Cluster Cluster
+
+	// New returns the factory for the backend's ensurers
+	New func(Target) (Factory, error)
+}
+
+// DefaultBackend is the name of the backend that applies objects to the cluster with server-side apply
+const DefaultBackend = "server-side"
+
+var (
+	backends     = map[string]Backend{}
+	backendsLock sync.RWMutex
+)
+
+func init() {
+	Register(Backend{
+		Name:        DefaultBackend,
+		Description: "apply objects to the cluster with server-side apply",
+		New: func(Target) (Factory, error) {
+			return DynamicFactory, nil
+		},
+	})
+	Register(Backend{
+		Name:        "client-side",
+		Description: "apply objects to the cluster with three-way merge patches, like kubectl apply",
+		New: func(Target) (Factory, error) {
+			return ClientSideFactory, nil
+		},
+	})
+	Register(Backend{
+		Name:        "dry-run",
+		Description: "report the changes that would be made to the cluster, without making them",
+		Cluster:     SimulatedCluster,
+		New: func(t Target) (Factory, error) {
+			if t.Out == nil {
+				return nil, fmt.Errorf("the dry-run backend needs an output")
+			}
+			return func(c Config) (Interface, error) {
+				return NewDynamicUnstructuredEnsurer(c.Client, c.Mapper, c.Cache, c.Instance, events.NewWriter(t.Out)), nil
+			}, nil
+		},
+	})
+	Register(Backend{
+		Name:        "yaml",
+		Description: "write objects to YAML files in a directory, instead of applying them to the cluster",
+		Cluster:     SimulatedCluster,
+		New: func(t Target) (Factory, error) {
+			if t.Dir == "" {
+				return nil, fmt.Errorf("the yaml backend needs a directory")
+			}
+			return func(c Config) (Interface, error) {
+				return NewDirEnsurer(NewDynamicUnstructuredEnsurer(c.Client, c.Mapper, c.Cache, c.Instance, nil), t.Dir)
+			}, nil
+		},
+	})
+	Register(Backend{
+		Name:        "memory",
+		Description: "apply objects to an empty in-memory cluster, i.e. for tests",
+		Cluster:     EmptyCluster,
+		New: func(Target) (Factory, error) {
+			return DynamicFactory, nil
+		},
+	})
+}
+
+// Register adds a backend. It panics if a backend with the same name is already registered.
+func Register(b Backend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	if _, ok := backends[b.Name]; ok {
+		panic(fmt.Sprintf("ensurer backend %q is already registered", b.Name))
+	}
+	backends[b.Name] = b
+}
+
+// Lookup returns the backend called name
+func Lookup(name string) (Backend, error) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	b, ok := backends[name]
+	if !ok {
+		return Backend{}, fmt.Errorf("unknown ensurer backend %q, expected one of: %s", name, strings.Join(backendNames(), ", "))
+	}
+	return b, nil
+}
+
+// Backends returns the registered backends, sorted by name
+func Backends() []Backend {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+	var bs []Backend
+	for _, name := range backendNames() {
+		bs = append(bs, backends[name])
+	}
+	return bs
+}
+
+func backendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
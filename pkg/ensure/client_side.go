@@ -0,0 +1,90 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+)
+
+// LastAppliedAnnotation is the object as it was last applied by a client-side ensurer
+const LastAppliedAnnotation = "cuebectl.io/last-applied"
+
+// NewClientSideEnsurer constructs an ensurer that updates existing objects with a three-way merge of the last applied
+// object, the object in the instance and the object in the cluster, like `kubectl apply` without --server-side. It is
+// for clusters, or policies, that don't allow server-side apply.
+func NewClientSideEnsurer(client dynamic.Interface, mapper meta.RESTMapper, cache cache.Interface, instance string, recorder *events.Recorder) *DynamicUnstructuredEnsurer {
+	e := NewDynamicUnstructuredEnsurer(client, mapper, cache, instance, recorder)
+	e.clientSide = true
+	return e
+}
+
+// merge updates an existing object with a three-way merge patch. Fields that were in the last applied object but are
+// no longer in the instance are removed, and fields set by others are kept.
+func (e *DynamicUnstructuredEnsurer) merge(resource schema.GroupVersionResource, in, existing *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	modified, err := in.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	current, err := existing.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	original := []byte(existing.GetAnnotations()[LastAppliedAnnotation])
+	pt, patch, err := threeWayPatch(in.GroupVersionKind(), original, modified, current)
+	if err != nil {
+		return nil, err
+	}
+	return e.client.Resource(resource).Namespace(in.GetNamespace()).Patch(context.TODO(), in.GetName(), pt, patch, v1.PatchOptions{FieldManager: "cuebectl"})
+}
+
+// threeWayPatch creates a strategic merge patch for kinds with Go types, so that lists merged by key (i.e. containers
+// and env) keep the items set by others, like `kubectl apply` does. Custom resources have no patch strategies, so they
+// get a JSON merge patch.
+func threeWayPatch(gvk schema.GroupVersionKind, original, modified, current []byte) (types.PatchType, []byte, error) {
+	obj, err := scheme.Scheme.New(gvk)
+	if runtime.IsNotRegisteredError(err) {
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current)
+		return types.MergePatchType, patch, err
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	lookup, err := strategicpatch.NewPatchMetaFromStruct(obj)
+	if err != nil {
+		return "", nil, err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(original, modified, current, lookup, true)
+	return types.StrategicMergePatchType, patch, err
+}
+
+// setLastApplied annotates u with itself, so that the next merge can tell which fields were removed from it
+func setLastApplied(u *unstructured.Unstructured) error {
+	annotations := u.GetAnnotations()
+	delete(annotations, LastAppliedAnnotation)
+	u.SetAnnotations(annotations)
+	b, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[LastAppliedAnnotation] = string(b)
+	u.SetAnnotations(annotations)
+	return nil
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+)
+
+var (
+	deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	widgets     = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+)
+
+// newEnsurer returns a client-side ensurer for instance "test" in an empty cluster, which knows the Widget kind
+func newEnsurer(t *testing.T) (*ensure.DynamicUnstructuredEnsurer, *simulate.Cluster) {
+	t.Helper()
+	cluster, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := harness.NewRESTMapper(harness.Kind{
+		GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"},
+		Namespaced:       true,
+	})
+	c := cache.NewDynamicInformerCache(cluster, "", workqueue.New())
+	return ensure.NewClientSideEnsurer(cluster, mapper, c, "test", nil), cluster
+}
+
+func deployment(image string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": image},
+					},
+				},
+			},
+		},
+	}}
+}
+
+func widget(items ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Widget",
+		"metadata":   map[string]interface{}{"name": "widget", "namespace": "default"},
+		"spec":       map[string]interface{}{"items": items},
+	}}
+}
+
+// set changes the field at fields of an object in the cluster, as if another client had
+func set(t *testing.T, cluster *simulate.Cluster, gvr schema.GroupVersionResource, name string, value interface{}, fields ...string) {
+	t.Helper()
+	client := cluster.Resource(gvr).Namespace("default")
+	u, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(u.Object, value, fields...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(context.Background(), u, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSideKeepsListItemsSetByOthers(t *testing.T) {
+	e, cluster := newEnsurer(t)
+	if _, _, err := e.EnsureUnstructured(deployment("app:v1"), ensure.Options{Path: []string{"web"}}); err != nil {
+		t.Fatal(err)
+	}
+	// i.e. a sidecar injected by a webhook
+	set(t, cluster, deployments, "web", []interface{}{
+		map[string]interface{}{"name": "app", "image": "app:v1"},
+		map[string]interface{}{"name": "sidecar", "image": "proxy:v1"},
+	}, "spec", "template", "spec", "containers")
+
+	out, _, err := e.EnsureUnstructured(deployment("app:v2"), ensure.Options{Path: []string{"web"}, Tracked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers, _, _ := unstructured.NestedSlice(out.Object, "spec", "template", "spec", "containers")
+	images := map[string]interface{}{}
+	for _, c := range containers {
+		c := c.(map[string]interface{})
+		images[c["name"].(string)] = c["image"]
+	}
+	if images["app"] != "app:v2" || images["sidecar"] != "proxy:v1" {
+		t.Errorf("got containers %v, want app updated to app:v2 and the sidecar kept", containers)
+	}
+}
+
+func TestClientSideCustomResource(t *testing.T) {
+	e, cluster := newEnsurer(t)
+	if _, _, err := e.EnsureUnstructured(widget("a"), ensure.Options{Path: []string{"widget"}}); err != nil {
+		t.Fatal(err)
+	}
+	set(t, cluster, widgets, "widget", int64(3), "spec", "size")
+
+	// custom resources have no patch strategies, so lists are replaced, but fields set by others are still kept
+	out, _, err := e.EnsureUnstructured(widget("b", "c"), ensure.Options{Path: []string{"widget"}, Tracked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, _ := unstructured.NestedSlice(out.Object, "spec", "items")
+	size, _, _ := unstructured.NestedFieldNoCopy(out.Object, "spec", "size")
+	if fmt.Sprint(items) != "[b c]" || fmt.Sprint(size) != "3" {
+		t.Errorf("got spec %v, want items [b c] and size 3", out.Object["spec"])
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+// generatedFields are populated by the cluster, or by the ensurer to find the field of an object in the cluster, and
+// aren't written to files
+var generatedFields = [][]string{
+	{"status"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "selfLink"},
+	{"metadata", "managedFields"},
+	{"metadata", "annotations", LastAppliedAnnotation},
+	{"metadata", "annotations", ObjectHashKey},
+	{"metadata", "annotations", PathAnnotation},
+	{"metadata", "annotations", SourceAnnotation},
+}
+
+// DirEnsurer writes each ensured object to a YAML file in a directory, i.e. for a GitOps tool to apply. Objects are
+// also ensured with another ensurer, normally in a simulated cluster, so that the instance can be unified with them.
+type DirEnsurer struct {
+	ensurer Interface
+	dir     string
+}
+
+var _ Interface = &DirEnsurer{}
+
+// NewDirEnsurer constructs an ensurer that writes objects ensured with ensurer to dir
+func NewDirEnsurer(ensurer Interface, dir string) (*DirEnsurer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirEnsurer{ensurer: ensurer, dir: dir}, nil
+}
+
+func (e *DirEnsurer) EnsureUnstructured(in *unstructured.Unstructured, options Options) (*unstructured.Unstructured, identity.Locator, error) {
+	out, locator, err := e.ensurer.EnsureUnstructured(in, options)
+	if err != nil {
+		return out, locator, err
+	}
+
+	// write the object from the instance, with the name it was given in the cluster
+	manifest := in.DeepCopy()
+	manifest.SetName(locator.Name)
+	manifest.SetGenerateName("")
+	for _, f := range generatedFields {
+		unstructured.RemoveNestedField(manifest.Object, f...)
+	}
+	if a, ok, _ := unstructured.NestedMap(manifest.Object, "metadata", "annotations"); ok && len(a) == 0 {
+		unstructured.RemoveNestedField(manifest.Object, "metadata", "annotations")
+	}
+
+	b, err := yaml.Marshal(manifest.Object)
+	if err != nil {
+		return out, locator, err
+	}
+	return out, locator, ioutil.WriteFile(e.file(options.Path), b, 0644)
+}
+
+// DeleteUnstructured removes the file for the object, and deletes it with the other ensurer
+func (e *DirEnsurer) DeleteUnstructured(locator identity.Locator) error {
+	if err := os.Remove(e.file(locator.Path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return e.ensurer.DeleteUnstructured(locator)
+}
+
+// file is the path of the file for the field at path
+func (e *DirEnsurer) file(path []string) string {
+	name := strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(strings.Join(path, "."))
+	return filepath.Join(e.dir, name+".yaml")
+}
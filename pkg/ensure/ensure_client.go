@@ -7,12 +7,15 @@ import (
 	"context"
 	"fmt"
 	"hash/fnv"
+	"sort"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
@@ -21,6 +24,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/events"
 	"github.com/cuebernetes/cuebectl/pkg/identity"
 )
 
@@ -31,58 +35,95 @@ type DynamicUnstructuredEnsurer struct {
 	client dynamic.Interface
 	cache  cache.Interface
 	mapper meta.RESTMapper
+
+	// instance is the name of the instance that owns ensured objects
+	instance string
+
+	recorder *events.Recorder
+
+	// clientSide is set if existing objects are updated with three-way merge patches instead of server-side apply
+	clientSide bool
 }
 
 var _ Interface = &DynamicUnstructuredEnsurer{}
 
 // NewDynamicUnstructuredEnsurer constructs a an ensurer from a dynamic.Interface and RESTMapper
-func NewDynamicUnstructuredEnsurer(client dynamic.Interface, mapper meta.RESTMapper, cache cache.Interface) *DynamicUnstructuredEnsurer {
+// Objects that are created or adopted are labelled as owned by instance, and annotated with their path and source.
+// Changes to objects are recorded as events with recorder, which may be nil.
+func NewDynamicUnstructuredEnsurer(client dynamic.Interface, mapper meta.RESTMapper, cache cache.Interface, instance string, recorder *events.Recorder) *DynamicUnstructuredEnsurer {
 	return &DynamicUnstructuredEnsurer{
-		client: client,
-		mapper: mapper,
-		cache:  cache,
+		client:   client,
+		mapper:   mapper,
+		cache:    cache,
+		instance: instance,
+		recorder: recorder,
 	}
 }
 
-func (e *DynamicUnstructuredEnsurer) EnsureUnstructured(in *unstructured.Unstructured) (out *unstructured.Unstructured, locator identity.Locator, err error) {
+func (e *DynamicUnstructuredEnsurer) EnsureUnstructured(in *unstructured.Unstructured, options Options) (out *unstructured.Unstructured, locator identity.Locator, err error) {
 	gvk := in.GroupVersionKind()
 	mapping, err := e.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 	if err != nil {
 		return
 	}
 
+	Stamp(in, e.instance, options)
+
 	// set hash of incoming object as an annotation
 	err = HashUnstructured(in)
 	if err != nil {
 		// unexpected hash error
 		return
 	}
+	if e.clientSide {
+		if err = setLastApplied(in); err != nil {
+			return
+		}
+	}
 
 	namespace := in.GetNamespace()
 
+	// bind to an existing object instead of generating a new one
+	if in.GetName() == "" && options.Selector != "" {
+		var name string
+		name, err = e.find(mapping.Resource, namespace, options.Selector)
+		if err != nil {
+			return
+		}
+		in.SetName(name)
+	}
+
+	// bind to the object created for this field by an earlier run (or another replica) of the instance, instead of
+	// generating another one
+	if in.GetName() == "" && in.GetGenerateName() != "" && len(options.Path) > 0 {
+		var name string
+		name, err = e.findManaged(mapping.Resource, namespace, strings.Join(options.Path, "."))
+		if err != nil {
+			return
+		}
+		in.SetName(name)
+	}
+
 	// create if no name
 	if in.GetName() == "" {
-		out, err = e.client.Resource(mapping.Resource).Namespace(namespace).Create(context.TODO(), in, v1.CreateOptions{FieldManager: "cuebectl"})
-		if err == nil && out != nil {
-			locator = identity.Locator{NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: mapping.Resource, Namespace: namespace}, Name: out.GetName()}
-		}
-		return
+		return e.create(mapping.Resource, in, options)
 	}
 
 	existing, err := e.get(mapping.Resource, namespace, in.GetName())
 	if errors.IsNotFound(err) {
 		// create if not exists
-		out, err = e.client.Resource(mapping.Resource).Namespace(namespace).Create(context.TODO(), in, v1.CreateOptions{FieldManager: "cuebectl"})
-		if err == nil && out != nil {
-			locator = identity.Locator{NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: mapping.Resource, Namespace: namespace}, Name: out.GetName()}
-		}
-		return
+		return e.create(mapping.Resource, in, options)
 	}
 	if err != nil {
 		// unexpected error
 		return
 	}
 
+	if err = CheckOwner(existing, e.instance, options); err != nil {
+		e.recorder.Warning(existing, options.Path, events.ConflictDetected, "%v", err)
+		return
+	}
+
 	locator = identity.Locator{NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: mapping.Resource, Namespace: namespace}, Name: in.GetName()}
 
 	if EqualHash(in, existing) {
@@ -91,44 +132,144 @@ func (e *DynamicUnstructuredEnsurer) EnsureUnstructured(in *unstructured.Unstruc
 	}
 
 	// apply if exists
+	if e.clientSide {
+		out, err = e.merge(mapping.Resource, in, existing)
+	} else {
+		out, err = e.apply(mapping.Resource, in)
+	}
+	if err == nil && out != nil {
+		e.recorder.Normal(out, options.Path, events.Configured, "configured %s %s", out.GetKind(), objectName(out))
+	}
+	return
+}
+
+// apply updates an existing object with a server-side apply patch
+func (e *DynamicUnstructuredEnsurer) apply(resource schema.GroupVersionResource, in *unstructured.Unstructured) (*unstructured.Unstructured, error) {
 	b, err := in.MarshalJSON()
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	// TODO: can the requirement to force be removed?
 	force := true
-	out, err = e.client.Resource(mapping.Resource).Namespace(namespace).Patch(context.TODO(), in.GetName(), types.ApplyPatchType, b, v1.PatchOptions{FieldManager: "cuebectl", Force: &force})
+	return e.client.Resource(resource).Namespace(in.GetNamespace()).Patch(context.TODO(), in.GetName(), types.ApplyPatchType, b, v1.PatchOptions{FieldManager: "cuebectl", Force: &force})
+}
+
+func (e *DynamicUnstructuredEnsurer) create(resource schema.GroupVersionResource, in *unstructured.Unstructured, options Options) (out *unstructured.Unstructured, locator identity.Locator, err error) {
+	out, err = e.client.Resource(resource).Namespace(in.GetNamespace()).Create(context.TODO(), in, v1.CreateOptions{FieldManager: "cuebectl"})
+	if err != nil || out == nil {
+		return
+	}
+	locator = identity.Locator{NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: resource, Namespace: in.GetNamespace()}, Name: out.GetName()}
+	if options.Tracked {
+		e.recorder.Normal(out, options.Path, events.Recreated, "recreated %s %s", out.GetKind(), objectName(out))
+	} else {
+		e.recorder.Normal(out, options.Path, events.Created, "created %s %s", out.GetKind(), objectName(out))
+	}
 	return
 }
 
+// DeleteUnstructured deletes the object identified by locator, if it exists and is managed by the instance.
+func (e *DynamicUnstructuredEnsurer) DeleteUnstructured(locator identity.Locator) error {
+	existing, err := e.get(locator.GroupVersionResource, locator.Namespace, locator.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if Owner(existing) != e.instance {
+		klog.V(2).Infof("not pruning %s %s, it is not managed by instance %s", existing.GetKind(), objectName(existing), e.instance)
+		return nil
+	}
+	uid := existing.GetUID()
+	err = e.client.Resource(locator.GroupVersionResource).Namespace(locator.Namespace).Delete(context.TODO(), locator.Name, v1.DeleteOptions{
+		Preconditions: &v1.Preconditions{UID: &uid},
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e.recorder.Normal(existing, locator.Path, events.Pruned, "deleted %s %s, the field is no longer in the instance", existing.GetKind(), objectName(existing))
+	return nil
+}
+
+// get returns an object from the cache of managed objects, or from the cluster if it isn't in the cache (i.e. because
+// it isn't managed by the instance yet)
 func (e *DynamicUnstructuredEnsurer) get(resource schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
-	ngvr := identity.NamespacedGroupVersionResource{GroupVersionResource: resource, Namespace: namespace}
-	informer := e.cache.Get(ngvr)
-	if informer == nil {
-		return e.client.Resource(resource).Namespace(namespace).Get(context.TODO(), name, v1.GetOptions{})
-	}
-	var o runtime.Object
-	var err error
-	if namespace == "" {
-		o, err = informer.Lister().Get(name)
-	} else {
-		o, err = informer.Lister().ByNamespace(namespace).Get(name)
+	locator := &identity.Locator{NamespacedGroupVersionResource: identity.NamespacedGroupVersionResource{GroupVersionResource: resource, Namespace: namespace}, Name: name}
+	if informer := e.cache.Get(locator); informer != nil {
+		var o runtime.Object
+		var err error
+		if namespace == "" {
+			o, err = informer.Lister().Get(name)
+		} else {
+			o, err = informer.Lister().ByNamespace(namespace).Get(name)
+		}
+		if err == nil {
+			return o.(*unstructured.Unstructured), nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
 	}
+	return e.client.Resource(resource).Namespace(namespace).Get(context.TODO(), name, v1.GetOptions{})
+}
+
+// find returns the name of the single object matching selector, or "" if there is none
+func (e *DynamicUnstructuredEnsurer) find(resource schema.GroupVersionResource, namespace, selector string) (string, error) {
+	list, err := e.client.Resource(resource).Namespace(namespace).List(context.TODO(), v1.ListOptions{LabelSelector: selector})
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	switch len(list.Items) {
+	case 0:
+		return "", nil
+	case 1:
+		return list.Items[0].GetName(), nil
+	default:
+		return "", fmt.Errorf("selector %q matches %d %s, expected at most one", selector, len(list.Items), resource.Resource)
+	}
+}
+
+// findManaged returns the name of the object managed by the instance for the field at path, or "" if there is none
+func (e *DynamicUnstructuredEnsurer) findManaged(resource schema.GroupVersionResource, namespace, path string) (string, error) {
+	selector := labels.SelectorFromSet(labels.Set{InstanceLabel: e.instance}).String()
+	list, err := e.client.Resource(resource).Namespace(namespace).List(context.TODO(), v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, item := range list.Items {
+		if item.GetAnnotations()[PathAnnotation] == path && item.GetDeletionTimestamp() == nil {
+			names = append(names, item.GetName())
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", nil
+	case 1:
+		return names[0], nil
+	default:
+		sort.Strings(names)
+		klog.Warningf("instance %s has %d %s for %s, using %s", e.instance, len(names), resource.Resource, path, names[0])
+		return names[0], nil
 	}
-	return o.(*unstructured.Unstructured), nil
 }
 
 // HashUnstructured writes specified object to hash using the spew library
 // which follows pointers and prints actual values of the nested objects
 // ensuring the hash does not change when a pointer changes.
 func HashUnstructured(u *unstructured.Unstructured) error {
-	// remove hash annotation before hashing
-	if _, ok := u.GetAnnotations()[ObjectHashKey]; ok {
-		a := u.GetAnnotations()
+	// remove the hash and last applied annotations before hashing, since they are derived from the hash
+	a := u.GetAnnotations()
+	_, hashed := a[ObjectHashKey]
+	_, applied := a[LastAppliedAnnotation]
+	if hashed || applied {
 		delete(a, ObjectHashKey)
+		delete(a, LastAppliedAnnotation)
 		u.SetAnnotations(a)
 	}
 	u.SetResourceVersion("")
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+)
+
+// Config is the input to a Factory
+type Config struct {
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+	Cache  cache.Interface
+
+	// Instance is the name of the instance that owns ensured objects
+	Instance string
+
+	// Recorder records events for changes to objects, and may be nil
+	Recorder *events.Recorder
+}
+
+// Factory constructs an ensurer for an instance
+type Factory func(Config) (Interface, error)
+
+// DynamicFactory constructs a DynamicUnstructuredEnsurer, which applies objects to the cluster
+func DynamicFactory(c Config) (Interface, error) {
+	return NewDynamicUnstructuredEnsurer(c.Client, c.Mapper, c.Cache, c.Instance, c.Recorder), nil
+}
+
+var _ Factory = DynamicFactory
+
+// ClientSideFactory constructs a DynamicUnstructuredEnsurer that applies objects to the cluster with three-way merge
+// patches
+func ClientSideFactory(c Config) (Interface, error) {
+	return NewClientSideEnsurer(c.Client, c.Mapper, c.Cache, c.Instance, c.Recorder), nil
+}
+
+var _ Factory = ClientSideFactory
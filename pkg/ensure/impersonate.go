@@ -0,0 +1,92 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+// Impersonator returns a client for the same cluster that makes requests as user
+type Impersonator func(user string) (dynamic.Interface, error)
+
+// ServiceAccountUser returns the username that the service account namespace/name authenticates as
+func ServiceAccountUser(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+// ImpersonatingEnsurer ensures objects whose options name a user with an ensurer whose client impersonates that user,
+// so that they are written with the user's permissions. Other objects are ensured with the default ensurer. Objects
+// are always deleted with the default ensurer.
+type ImpersonatingEnsurer struct {
+	ensurer     Interface
+	factory     Factory
+	config      Config
+	impersonate Impersonator
+
+	mu    sync.Mutex
+	users map[string]Interface
+}
+
+var _ Interface = &ImpersonatingEnsurer{}
+
+// NewImpersonatingEnsurer constructs the default ensurer from config with factory, and the ensurer for each user
+// from the same config with the client returned by impersonate. If impersonate is nil, objects that name a user fail.
+func NewImpersonatingEnsurer(factory Factory, config Config, impersonate Impersonator) (*ImpersonatingEnsurer, error) {
+	ensurer, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ImpersonatingEnsurer{
+		ensurer:     ensurer,
+		factory:     factory,
+		config:      config,
+		impersonate: impersonate,
+		users:       map[string]Interface{},
+	}, nil
+}
+
+func (e *ImpersonatingEnsurer) EnsureUnstructured(in *unstructured.Unstructured, options Options) (*unstructured.Unstructured, identity.Locator, error) {
+	if options.User == "" {
+		return e.ensurer.EnsureUnstructured(in, options)
+	}
+	ensurer, err := e.forUser(options.User)
+	if err != nil {
+		return nil, identity.Locator{}, err
+	}
+	return ensurer.EnsureUnstructured(in, options)
+}
+
+func (e *ImpersonatingEnsurer) DeleteUnstructured(locator identity.Locator) error {
+	return e.ensurer.DeleteUnstructured(locator)
+}
+
+// forUser returns the ensurer that impersonates user, constructing it on first use
+func (e *ImpersonatingEnsurer) forUser(user string) (Interface, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ensurer, ok := e.users[user]; ok {
+		return ensurer, nil
+	}
+	if e.impersonate == nil {
+		return nil, fmt.Errorf("can't impersonate %s: impersonation is not supported for this cluster", user)
+	}
+	client, err := e.impersonate(user)
+	if err != nil {
+		return nil, fmt.Errorf("can't impersonate %s: %v", user, err)
+	}
+	config := e.config
+	config.Client = client
+	ensurer, err := e.factory(config)
+	if err != nil {
+		return nil, err
+	}
+	e.users[user] = ensurer
+	return ensurer, nil
+}
@@ -12,5 +12,9 @@ type Interface interface {
 	// Ensure takes an unstructured object and ensures that it is either created or updated on the cluster, returning
 	// the updated object or an error.
 	// It should be used for resources that have been concreted via cue instance / cluster reconciliation
-	EnsureUnstructured(*unstructured.Unstructured) (*unstructured.Unstructured, identity.Locator, error)
+	EnsureUnstructured(*unstructured.Unstructured, Options) (*unstructured.Unstructured, identity.Locator, error)
+
+	// DeleteUnstructured deletes the object identified by a locator, if it is managed by the instance. It is used to
+	// prune objects whose fields are no longer in the instance.
+	DeleteUnstructured(identity.Locator) error
 }
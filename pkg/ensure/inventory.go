@@ -0,0 +1,42 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// EnsureInventory returns the inventory object of instance in namespace, creating it if it doesn't exist. Events for
+// fields that don't have an object yet are recorded on the inventory object.
+func EnsureInventory(ctx context.Context, client dynamic.Interface, namespace, instance string) (*unstructured.Unstructured, error) {
+	name := ResourceName(instance)
+	configMaps := client.Resource(configMapGVR).Namespace(namespace)
+	existing, err := configMaps.Get(ctx, name, v1.GetOptions{})
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	inventory := &unstructured.Unstructured{}
+	inventory.SetAPIVersion("v1")
+	inventory.SetKind("ConfigMap")
+	inventory.SetNamespace(namespace)
+	inventory.SetName(name)
+	SetOwner(inventory, instance)
+	created, err := configMaps.Create(ctx, inventory, v1.CreateOptions{FieldManager: "cuebectl"})
+	if errors.IsAlreadyExists(err) {
+		return configMaps.Get(ctx, name, v1.GetOptions{})
+	}
+	return created, err
+}
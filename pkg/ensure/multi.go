@@ -0,0 +1,43 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+// MultiClusterEnsurer ensures objects with the ensurer for the kube context in their options. Locators of ensured
+// objects are returned with their context set.
+type MultiClusterEnsurer struct {
+	ensurers map[string]Interface
+}
+
+var _ Interface = &MultiClusterEnsurer{}
+
+// NewMultiClusterEnsurer constructs an ensurer from an ensurer per kube context, where "" is the default cluster
+func NewMultiClusterEnsurer(ensurers map[string]Interface) *MultiClusterEnsurer {
+	return &MultiClusterEnsurer{ensurers: ensurers}
+}
+
+func (e *MultiClusterEnsurer) EnsureUnstructured(in *unstructured.Unstructured, options Options) (*unstructured.Unstructured, identity.Locator, error) {
+	ensurer, ok := e.ensurers[options.Context]
+	if !ok {
+		return nil, identity.Locator{}, fmt.Errorf("unknown kube context %q", options.Context)
+	}
+	out, locator, err := ensurer.EnsureUnstructured(in, options)
+	locator.Context = options.Context
+	return out, locator, err
+}
+
+func (e *MultiClusterEnsurer) DeleteUnstructured(locator identity.Locator) error {
+	ensurer, ok := e.ensurers[locator.Context]
+	if !ok {
+		return fmt.Errorf("unknown kube context %q", locator.Context)
+	}
+	return ensurer.DeleteUnstructured(locator)
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// InstanceLabel identifies the cuebectl instance that manages an object
+	InstanceLabel = "cuebectl.io/instance"
+
+	// ManagedByLabel is the well-known label for the tool managing an object, set to "cuebectl"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+
+	// PathAnnotation is the path of the field in the cue instance that produced an object
+	PathAnnotation = "cuebectl.io/path"
+
+	// SourceAnnotation is the file:line position of the field in the cue instance that produced an object
+	SourceAnnotation = "cuebectl.io/source"
+)
+
+// Options control how an object is ensured
+type Options struct {
+	// Adopt allows an existing object that isn't managed by any instance to be taken over
+	Adopt bool
+
+	// ForceAdopt allows an existing object that is managed by another instance to be taken over
+	ForceAdopt bool
+
+	// Selector is a label selector used to find an existing object to adopt when the object has no name
+	Selector string
+
+	// Path is the path of the field in the instance that produced the object
+	Path []string
+
+	// Source is the file:line position of the field in the instance that produced the object
+	Source string
+
+	// Tracked is set if an object has been applied for the field before
+	Tracked bool
+
+	// Context is the kube context of the cluster to ensure the object in, or "" for the default cluster
+	Context string
+
+	// User is the user to impersonate when writing the object, or "" to write it as the client's user
+	User string
+}
+
+// InstanceName converts s into a valid instance name, so that it can be used as a label value
+func InstanceName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}, s)
+	if len(name) > validation.LabelValueMaxLength {
+		name = name[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(name, "-_.")
+}
+
+// ResourceName converts an instance name into a name for an object that belongs to the instance itself, such as its
+// lease or inventory
+func ResourceName(instance string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, instance)
+	return strings.Trim("cuebectl-"+name, "-.")
+}
+
+// Owner returns the name of the instance that manages u, or "" if it is unmanaged
+func Owner(u *unstructured.Unstructured) string {
+	return u.GetLabels()[InstanceLabel]
+}
+
+// SetOwner labels u as managed by instance
+func SetOwner(u *unstructured.Unstructured, instance string) {
+	labels := u.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[InstanceLabel] = instance
+	labels[ManagedByLabel] = "cuebectl"
+	u.SetLabels(labels)
+}
+
+// Stamp labels u as managed by instance, and annotates it with the field in the instance that produced it
+func Stamp(u *unstructured.Unstructured, instance string, options Options) {
+	SetOwner(u, instance)
+
+	annotations := u.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if len(options.Path) > 0 {
+		annotations[PathAnnotation] = strings.Join(options.Path, ".")
+	}
+	if options.Source != "" {
+		annotations[SourceAnnotation] = options.Source
+	}
+	u.SetAnnotations(annotations)
+}
+
+// CheckOwner returns an error if instance may not manage existing
+func CheckOwner(existing *unstructured.Unstructured, instance string, options Options) error {
+	owner := Owner(existing)
+	switch {
+	case owner == instance:
+		return nil
+	case owner == "" && options.Adopt:
+		klog.V(2).Infof("adopting %s %s", existing.GroupVersionKind().Kind, objectName(existing))
+		return nil
+	case owner == "" && hasHash(existing):
+		// created by a version of cuebectl that didn't label objects, but the annotation can't tell which instance
+		return fmt.Errorf("%s %s was created by a version of cuebectl that didn't record its instance: adopt it with --adopt or @cuebectl(adopt)",
+			existing.GroupVersionKind().Kind, objectName(existing))
+	case owner == "":
+		return fmt.Errorf("%s %s already exists and is not managed by cuebectl: adopt it with --adopt or @cuebectl(adopt)",
+			existing.GroupVersionKind().Kind, objectName(existing))
+	case options.ForceAdopt:
+		klog.V(2).Infof("taking over %s %s from instance %s", existing.GroupVersionKind().Kind, objectName(existing), owner)
+		return nil
+	default:
+		return fmt.Errorf("%s %s is managed by cuebectl instance %q: take it over with --force-adopt or @cuebectl(adopt,force)",
+			existing.GroupVersionKind().Kind, objectName(existing), owner)
+	}
+}
+
+func hasHash(u *unstructured.Unstructured) bool {
+	_, ok := u.GetAnnotations()[ObjectHashKey]
+	return ok
+}
+
+func objectName(u *unstructured.Unstructured) string {
+	if u.GetNamespace() == "" {
+		return u.GetName()
+	}
+	return u.GetNamespace() + "/" + u.GetName()
+}
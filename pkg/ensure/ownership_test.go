@@ -0,0 +1,119 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ensure_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// configMap returns a config map labelled as managed by owner, unless owner is "", with the given annotations
+func configMap(owner string, annotations map[string]string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "config", "namespace": "default"},
+		"data":       map[string]interface{}{"key": "value"},
+	}}
+	if owner != "" {
+		ensure.SetOwner(u, owner)
+	}
+	u.SetAnnotations(annotations)
+	return u
+}
+
+func TestCheckOwner(t *testing.T) {
+	hashed := map[string]string{ensure.ObjectHashKey: "abc"}
+	tests := []struct {
+		name     string
+		existing *unstructured.Unstructured
+		options  ensure.Options
+		err      string
+	}{
+		{name: "owned", existing: configMap("test", nil)},
+		{name: "unmanaged", existing: configMap("", nil), err: "not managed by cuebectl"},
+		{name: "unmanaged adopted", existing: configMap("", nil), options: ensure.Options{Adopt: true}},
+		{name: "unlabelled with hash", existing: configMap("", hashed), err: "didn't record its instance"},
+		{name: "unlabelled with hash adopted", existing: configMap("", hashed), options: ensure.Options{Adopt: true}},
+		{name: "other instance", existing: configMap("other", nil), err: `managed by cuebectl instance "other"`},
+		{name: "other instance adopted", existing: configMap("other", nil), options: ensure.Options{Adopt: true}, err: "--force-adopt"},
+		{name: "other instance taken over", existing: configMap("other", nil), options: ensure.Options{ForceAdopt: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ensure.CheckOwner(tt.existing, "test", tt.options)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestStamp(t *testing.T) {
+	u := configMap("", map[string]string{"keep": "me"})
+	ensure.Stamp(u, "test", ensure.Options{Path: []string{"apps", "config"}, Source: "app.cue:3:1"})
+	if ensure.Owner(u) != "test" || u.GetLabels()[ensure.ManagedByLabel] != "cuebectl" {
+		t.Errorf("got labels %v, want the instance and managed-by labels", u.GetLabels())
+	}
+	want := map[string]string{"keep": "me", ensure.PathAnnotation: "apps.config", ensure.SourceAnnotation: "app.cue:3:1"}
+	for k, v := range want {
+		if got := u.GetAnnotations()[k]; got != v {
+			t.Errorf("got annotation %s=%q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	tests := []struct {
+		in, instance, resource string
+	}{
+		{in: "app", instance: "app", resource: "cuebectl-app"},
+		{in: "My App/v1", instance: "My-App-v1", resource: "cuebectl-my-app-v1"},
+		{in: "-app_", instance: "app", resource: "cuebectl--app"},
+		{in: strings.Repeat("a", 70), instance: strings.Repeat("a", 63), resource: "cuebectl-" + strings.Repeat("a", 70)},
+	}
+	for _, tt := range tests {
+		if got := ensure.InstanceName(tt.in); got != tt.instance {
+			t.Errorf("InstanceName(%q) = %q, want %q", tt.in, got, tt.instance)
+		}
+		if got := ensure.ResourceName(tt.in); got != tt.resource {
+			t.Errorf("ResourceName(%q) = %q, want %q", tt.in, got, tt.resource)
+		}
+	}
+}
+
+func TestEnsureUnlabelledObjectWithHash(t *testing.T) {
+	e, cluster := newEnsurer(t)
+	// as left by a version of cuebectl that didn't label objects
+	if err := cluster.Create(configMaps, configMap("", map[string]string{ensure.ObjectHashKey: "abc"})); err != nil {
+		t.Fatal(err)
+	}
+	in := configMap("", nil)
+	in.Object["data"] = map[string]interface{}{"key": "changed"}
+	if _, _, err := e.EnsureUnstructured(in.DeepCopy(), ensure.Options{Path: []string{"config"}}); err == nil {
+		t.Fatal("expected an unlabelled object to only be adopted explicitly")
+	}
+	if _, _, err := e.EnsureUnstructured(in.DeepCopy(), ensure.Options{Path: []string{"config"}, Adopt: true}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := cluster.Resource(configMaps).Namespace("default").Get(context.Background(), "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ensure.Owner(u) != "test" || u.Object["data"].(map[string]interface{})["key"] != "changed" {
+		t.Errorf("got %v, want the object adopted by test and updated", u.Object)
+	}
+}
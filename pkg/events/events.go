@@ -0,0 +1,120 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package events
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Reasons for events recorded for managed objects
+const (
+	// Created is recorded when an object is created for a field
+	Created = "Created"
+
+	// Configured is recorded when an existing object is updated to match its field
+	Configured = "Configured"
+
+	// ConflictDetected is recorded when an existing object can't be managed because of its owner
+	ConflictDetected = "ConflictDetected"
+
+	// NotConcrete is recorded when a field can't be applied yet because it isn't concrete
+	NotConcrete = "NotConcrete"
+
+	// Pruned is recorded when an object is deleted because its field is no longer in the instance
+	Pruned = "Pruned"
+
+	// Recreated is recorded when an object that was applied before is created again, i.e. after it was deleted
+	Recreated = "Recreated"
+)
+
+// Component is the source of recorded events
+const Component = "cuebectl"
+
+// Recorder records events for the fields of an instance. Events are recorded on the object produced by a field, or on
+// the inventory object of the instance if there is no such object yet. A nil Recorder records nothing.
+type Recorder struct {
+	recorder  record.EventRecorder
+	inventory runtime.Object
+}
+
+// NewRecorder returns a Recorder that records with recorder. inventory may be nil, in which case events without an
+// object are only logged.
+func NewRecorder(recorder record.EventRecorder, inventory runtime.Object) *Recorder {
+	return &Recorder{recorder: recorder, inventory: inventory}
+}
+
+// NewBroadcaster returns a broadcaster that writes events with client, and a recorder that records to it. The
+// broadcaster should be shut down when done.
+func NewBroadcaster(client kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster, broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: Component})
+}
+
+// Normal records an informational event for the field at path
+func (r *Recorder) Normal(obj runtime.Object, path []string, reason, messageFmt string, args ...interface{}) {
+	r.event(obj, corev1.EventTypeNormal, path, reason, messageFmt, args...)
+}
+
+// Warning records a warning event for the field at path
+func (r *Recorder) Warning(obj runtime.Object, path []string, reason, messageFmt string, args ...interface{}) {
+	r.event(obj, corev1.EventTypeWarning, path, reason, messageFmt, args...)
+}
+
+func (r *Recorder) event(obj runtime.Object, eventtype string, path []string, reason, messageFmt string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	message := fmt.Sprintf("%s: %s", strings.Join(path, "."), fmt.Sprintf(messageFmt, args...))
+	if obj == nil {
+		obj = r.inventory
+	}
+	if obj == nil {
+		klog.V(2).Infof("%s %s: %s", eventtype, reason, message)
+		return
+	}
+	r.recorder.Event(obj, eventtype, reason, message)
+}
+
+// NewWriter returns a Recorder that writes the messages of events to out instead of recording them, i.e. to report
+// what a dry run would change
+func NewWriter(out io.Writer) *Recorder {
+	return &Recorder{recorder: &writer{out: out}}
+}
+
+// writer is a record.EventRecorder that writes messages
+type writer struct {
+	out io.Writer
+	sync.Mutex
+}
+
+var _ record.EventRecorder = &writer{}
+
+func (w *writer) Event(_ runtime.Object, eventtype, _, message string) {
+	w.Lock()
+	defer w.Unlock()
+	if eventtype == corev1.EventTypeWarning {
+		message = "warning: " + message
+	}
+	fmt.Fprintln(w.out, message)
+}
+
+func (w *writer) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	w.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (w *writer) AnnotatedEventf(object runtime.Object, _ map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	w.Eventf(object, eventtype, reason, messageFmt, args...)
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package facts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/build"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+)
+
+// Label is the hidden field in the instance that cluster facts are filled into
+const Label = "_cluster"
+
+// Cluster holds facts about the target cluster that cue definitions can branch on
+type Cluster struct {
+	Version Version `json:"version"`
+
+	// APIResources maps each served groupVersion to the kinds it serves, and their resource names, e.g.
+	// apiResources: "policy/v1beta1": PodSecurityPolicy: "podsecuritypolicies"
+	APIResources map[string]map[string]string `json:"apiResources"`
+
+	// Context is the name of the kubeconfig context in use
+	Context string `json:"context"`
+
+	// Namespace is the default namespace of the context
+	Namespace string `json:"namespace"`
+}
+
+// Version is the version of the kube apiserver
+type Version struct {
+	Major      int    `json:"major"`
+	Minor      int    `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+}
+
+// Discover reads cluster facts from the discovery api
+func Discover(client discovery.DiscoveryInterface, context, namespace string) (*Cluster, error) {
+	info, err := client.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	major, err := versionNumber(info.Major)
+	if err != nil {
+		return nil, err
+	}
+	minor, err := versionNumber(info.Minor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return nil, err
+		}
+		klog.Warningf("discovery is incomplete, some api resources will be missing: %v", err)
+	}
+	resources := map[string]map[string]string{}
+	for _, list := range lists {
+		if _, err := schema.ParseGroupVersion(list.GroupVersion); err != nil {
+			return nil, err
+		}
+		kinds := map[string]string{}
+		for _, r := range list.APIResources {
+			// skip subresources
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			kinds[r.Kind] = r.Name
+		}
+		resources[list.GroupVersion] = kinds
+	}
+
+	return &Cluster{
+		Version: Version{
+			Major:      major,
+			Minor:      minor,
+			GitVersion: info.GitVersion,
+		},
+		APIResources: resources,
+		Context:      context,
+		Namespace:    namespace,
+	}, nil
+}
+
+// versionNumber parses major and minor versions, which some providers suffix (i.e. "19+")
+func versionNumber(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected server version %q: %v", s, err)
+	}
+	return n, nil
+}
+
+// Fill unifies cluster facts into the instance at Label. Hidden fields are scoped to their package, so the facts
+// are built as part of the same package as b, the build instance that instance was built from. Packages that don't
+// declare Label are returned unchanged.
+func Fill(runtime *cue.Runtime, b *build.Instance, instance *cue.Instance, c *Cluster) (*cue.Instance, error) {
+	if !declared(b) {
+		return instance, nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	src := fmt.Sprintf("%s: %s\n", Label, data)
+	if b.PkgName != "" {
+		src = fmt.Sprintf("package %s\n\n%s", b.PkgName, src)
+	}
+
+	factsInstance := build.NewContext().NewInstance(b.Dir, nil)
+	factsInstance.Module = b.Module
+	if err := factsInstance.AddFile("cuebectl_cluster.cue", src); err != nil {
+		return nil, err
+	}
+	facts, err := runtime.Build(factsInstance)
+	if err != nil {
+		return nil, err
+	}
+	return instance.Fill(facts.Value())
+}
+
+// declared returns true if a file of b declares Label at the top level. References to Label don't build unless it is
+// declared, i.e. with `_cluster: _`.
+func declared(b *build.Instance) bool {
+	for _, f := range b.Files {
+		for _, d := range f.Decls {
+			field, ok := d.(*ast.Field)
+			if !ok {
+				continue
+			}
+			if name, _, err := ast.LabelName(field.Label); err == nil && name == Label {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package harness
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CRDKinds returns the kinds defined by the CustomResourceDefinitions in objs, so that fixtures can define the custom
+// resources they use
+func CRDKinds(objs []*unstructured.Unstructured) []Kind {
+	var kinds []Kind
+	for _, o := range objs {
+		if o.GroupVersionKind().GroupKind() != (schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}) {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(o.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(o.Object, "spec", "names", "kind")
+		plural, _, _ := unstructured.NestedString(o.Object, "spec", "names", "plural")
+		scope, _, _ := unstructured.NestedString(o.Object, "spec", "scope")
+		versions, _, _ := unstructured.NestedSlice(o.Object, "spec", "versions")
+		for _, v := range versions {
+			version, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(version, "name")
+			kinds = append(kinds, Kind{
+				GroupVersionKind: schema.GroupVersionKind{Group: group, Version: name, Kind: kind},
+				Resource:         plural,
+				Namespaced:       scope == "Namespaced",
+			})
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package harness
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cuebernetes/cuebectl/pkg/importer"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
+)
+
+// Mismatch is an object that differs from its golden file
+type Mismatch struct {
+	// Path of the field that produced the object, or "" if the golden file has no field
+	Path string
+	File string
+
+	// Diff is a unified diff from the golden file to the object
+	Diff string
+}
+
+// Golden compares the object of each applied field with the golden file <dir>/<path>.yaml. Fields populated by the
+// cluster, and the labels and annotations cuebectl adds to track the object, are left out of the comparison. Golden
+// files without a field are mismatches too. Sensitive values are compared, but masked in diffs unless the instance was
+// applied with ShowSecrets.
+//
+// If update is set, the golden files are rewritten to match the objects instead, and golden files without a field
+// are removed.
+func (r *Result) Golden(dir string, update bool) ([]Mismatch, error) {
+	if update {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	files := map[string]bool{}
+	var paths []string
+	for path, o := range r.Outcomes {
+		if o.Status == reconcile.Applied {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	if !update {
+		if err := r.observeGolden(dir, paths); err != nil {
+			return nil, err
+		}
+	}
+
+	var mismatches []Mismatch
+	for _, path := range paths {
+		file := GoldenFile(dir, path)
+		files[file] = true
+		got, err := yaml.Marshal(goldenObject(r.Outcomes[path].Object).Object)
+		if err != nil {
+			return nil, err
+		}
+		if update {
+			if err := ioutil.WriteFile(file, got, 0644); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if !bytes.Equal(got, want) {
+			diff, err := r.redactedDiff(file, path, want, got)
+			if err != nil {
+				return nil, err
+			}
+			mismatches = append(mismatches, Mismatch{Path: path, File: file, Diff: diff})
+		}
+	}
+
+	// golden files for fields that are no longer applied
+	existing, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range existing {
+		if files[file] {
+			continue
+		}
+		if update {
+			if err := os.Remove(file); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		diff, err := r.redactedDiff(file, "(no field)", want, nil)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, Mismatch{File: file, Diff: diff})
+	}
+	return mismatches, nil
+}
+
+// goldenObject returns u without the fields that are left out of golden files, i.e. the source position of its field,
+// which changes with unrelated edits to the package
+func goldenObject(u *unstructured.Unstructured) *unstructured.Unstructured {
+	return importer.Strip(u)
+}
+
+// GoldenFile is the golden file in dir for the field at path
+func GoldenFile(dir, path string) string {
+	name := strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(path)
+	return filepath.Join(dir, name+".yaml")
+}
+
+// redactedDiff is a unified diff between the yaml objects a and b, for the field at path, with sensitive values masked
+func (r *Result) redactedDiff(from, path string, a, b []byte) (string, error) {
+	if r.Redactor == nil {
+		return unifiedDiff(from, path, a, b)
+	}
+	a, b = r.redactYAML(path, a), r.redactYAML(path, b)
+	if bytes.Equal(a, b) {
+		return "sensitive values differ, rerun with secrets shown to see them\n", nil
+	}
+	diff, err := unifiedDiff(from, path, a, b)
+	return r.Redactor.String(diff), err
+}
+
+// observeGolden collects the sensitive values of every golden file in dir, before any diff is made, since golden
+// files may hold values the cluster never had, i.e. an old Secret
+func (r *Result) observeGolden(dir string, paths []string) error {
+	if r.Redactor == nil {
+		return nil
+	}
+	fields := map[string]string{}
+	for _, path := range paths {
+		fields[GoldenFile(dir, path)] = path
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range existing {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		u := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(b, &u.Object); err != nil || u.Object == nil {
+			continue
+		}
+		r.Redactor.Observe(fields[file], u)
+	}
+	return nil
+}
+
+// redactYAML masks the sensitive values of a yaml object for the field at path
+func (r *Result) redactYAML(path string, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(b, &u.Object); err != nil {
+		return []byte(r.Redactor.String(string(b)))
+	}
+	redacted, err := yaml.Marshal(r.Redactor.Object(path, u).Object)
+	if err != nil {
+		return []byte(r.Redactor.String(string(b)))
+	}
+	return redacted
+}
+
+func unifiedDiff(from, to string, a, b []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	})
+}
@@ -0,0 +1,241 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package harness applies cue instances to a simulated cluster, so that cuebectl and cue packages can be tested
+// without a cluster.
+package harness
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/cuebernetes/cuebectl/pkg/apply"
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+)
+
+// DefaultTimeout is how long Apply waits for an instance to converge by default
+const DefaultTimeout = 30 * time.Second
+
+// Harness applies cue instances to a simulated cluster. The cluster starts out empty, and keeps the objects of every
+// instance applied to it, so that an instance can be applied again after the cluster changes.
+type Harness struct {
+	Cluster *simulate.Cluster
+	Mapper  meta.RESTMapper
+
+	// Options are the options for every apply. Backend and Target are ignored.
+	Options reconcile.Options
+
+	// Timeout is how long Apply waits for an instance to converge
+	Timeout time.Duration
+
+	// contexts are the clusters for fields with @cuebectl(context=...), keyed by context
+	contexts map[string]*simulate.Cluster
+}
+
+// New returns a harness with an empty cluster that knows the built-in kinds and kinds
+func New(kinds ...Kind) (*Harness, error) {
+	cluster, err := simulate.NewCluster(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Harness{
+		Cluster:  cluster,
+		Mapper:   NewRESTMapper(kinds...),
+		Options:  reconcile.Options{Name: "test"},
+		Timeout:  DefaultTimeout,
+		contexts: map[string]*simulate.Cluster{},
+	}, nil
+}
+
+// Context returns the cluster for fields with @cuebectl(context=name), creating an empty one if needed. It uses the
+// same RESTMapper as the default cluster.
+func (h *Harness) Context(name string) (*simulate.Cluster, error) {
+	if c, ok := h.contexts[name]; ok {
+		return c, nil
+	}
+	c, err := simulate.NewCluster(nil)
+	if err != nil {
+		return nil, err
+	}
+	h.contexts[name] = c
+	return c, nil
+}
+
+// Seed adds objects to the cluster as they are, i.e. with the generated names or status that an instance depends on
+func (h *Harness) Seed(objs ...*unstructured.Unstructured) error {
+	for _, o := range objs {
+		gvk := o.GroupVersionKind()
+		mapping, err := h.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return err
+		}
+		if err := h.Cluster.Create(mapping.Resource, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mutate changes objects of kind gk when they are created or updated, i.e. to simulate a controller setting status
+func (h *Harness) Mutate(gk schema.GroupKind, m simulate.Mutator) {
+	h.Cluster.Mutate(gk, m)
+}
+
+// Apply loads the cue instance in dir and applies it until it converges
+func (h *Harness) Apply(dir string) (*Result, error) {
+	r, _, instance, err := apply.Load(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.ApplyInstance(r, instance)
+}
+
+// ApplyInstance applies instance, which was built with runtime, until it converges. If it doesn't converge within the
+// timeout, the result so far is returned with an error that lists the fields that failed.
+func (h *Harness) ApplyInstance(runtime *cue.Runtime, instance *cue.Instance) (*Result, error) {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	options := h.Options
+	options.Backend = ""
+	options.Termination = reconcile.UntilConverged
+	contexts, err := attributes.Contexts(instance.Value())
+	if err != nil {
+		return nil, err
+	}
+	options.Clusters = map[string]controller.Cluster{}
+	for _, name := range contexts {
+		c, err := h.Context(name)
+		if err != nil {
+			return nil, err
+		}
+		options.Clusters[name] = controller.Cluster{Client: c, Mapper: h.Mapper, Impersonate: impersonate(c)}
+	}
+	options.Impersonate = impersonate(h.Cluster)
+	r, err := reconcile.NewReconciler(h.Cluster, h.Mapper, runtime, instance, options)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.Run(ctx)
+	if err != nil {
+		return &Result{result}, err
+	}
+	if !result.Converged {
+		return &Result{result}, fmt.Errorf("did not converge within %s: %s", timeout, failures(result))
+	}
+	return &Result{result}, nil
+}
+
+// impersonate returns an impersonator for c that ignores the user, since the simulated cluster doesn't authorize
+// requests
+func impersonate(c *simulate.Cluster) ensure.Impersonator {
+	return func(string) (dynamic.Interface, error) {
+		return c, nil
+	}
+}
+
+// failures describes the fields of result that haven't been applied
+func failures(result *reconcile.Result) string {
+	var fs []string
+	for path, o := range result.Outcomes {
+		if o.Status == reconcile.Failed {
+			fs = append(fs, fmt.Sprintf("%s: %v", path, o.Err))
+		}
+	}
+	if len(fs) == 0 {
+		return fmt.Sprintf("%d of %d fields applied", len(result.Outcomes), result.Total)
+	}
+	sort.Strings(fs)
+	return strings.Join(fs, "; ")
+}
+
+// Result is the result of applying an instance to the simulated cluster
+type Result struct {
+	*reconcile.Result
+}
+
+// Object returns the object in the cluster for the field at path
+func (r *Result) Object(path string) (*unstructured.Unstructured, error) {
+	o, ok := r.Outcomes[path]
+	if !ok {
+		return nil, fmt.Errorf("%s was not applied", path)
+	}
+	if o.Object == nil {
+		return nil, fmt.Errorf("%s was not applied: %v", path, o.Err)
+	}
+	return o.Object, nil
+}
+
+// Field returns the value of a field of the object for the field at path
+func (r *Result) Field(path string, fields ...string) (interface{}, error) {
+	u, err := r.Object(path)
+	if err != nil {
+		return nil, err
+	}
+	v, ok, err := unstructured.NestedFieldCopy(u.Object, fields...)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s has no field %s", path, strings.Join(fields, "."))
+	}
+	return v, nil
+}
+
+// T is the part of testing.TB that assertions use
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertField checks that the object for the field at path has a field with the value want
+func (r *Result) AssertField(t T, want interface{}, path string, fields ...string) {
+	t.Helper()
+	got, err := r.Field(path, fields...)
+	if err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s %s: got %#v, want %#v", path, strings.Join(fields, "."), got, want)
+	}
+}
+
+// AssertStatus checks that the field at path has the status want
+func (r *Result) AssertStatus(t T, want reconcile.Status, path string) {
+	t.Helper()
+	o, ok := r.Outcomes[path]
+	if !ok {
+		t.Errorf("%s: no outcome, want %s", path, want)
+		return
+	}
+	if o.Status != want {
+		t.Errorf("%s: got %s, want %s (%v)", path, o.Status, want, o.Err)
+	}
+}
+
+// AssertApplied checks that every field of the instance was applied
+func (r *Result) AssertApplied(t T) {
+	t.Helper()
+	if !r.Converged {
+		t.Errorf("did not converge: %s", failures(r.Result))
+	}
+}
@@ -0,0 +1,385 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package harness_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/reconcile"
+)
+
+// recorder is a harness.T that records failed assertions
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func apply(t *testing.T, h *harness.Harness, src string) *harness.Result {
+	t.Helper()
+	r := &cue.Runtime{}
+	instance, err := r.Compile("test.cue", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.ApplyInstance(r, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func newHarness(t *testing.T, kinds ...harness.Kind) *harness.Harness {
+	t.Helper()
+	h, err := harness.New(kinds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Timeout = 10 * time.Second
+	return h
+}
+
+func TestApplyGenerateName(t *testing.T) {
+	h := newHarness(t)
+	result := apply(t, h, `
+ns: {
+	apiVersion: "v1"
+	kind:       "Namespace"
+	metadata: generateName: "test-"
+}
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: ns.metadata.name}
+	data: namespace: ns.metadata.name
+}
+`)
+	result.AssertApplied(t)
+	name, err := result.Field("ns", "metadata", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(name.(string), "test-") || name == "test-" {
+		t.Errorf("got name %q, want a name generated from test-", name)
+	}
+	result.AssertField(t, name, "config", "metadata", "namespace")
+	result.AssertField(t, name, "config", "data", "namespace")
+}
+
+func TestApplyReference(t *testing.T) {
+	h := newHarness(t)
+	if err := h.Seed(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "existing", "namespace": "default"},
+		"data":       map[string]interface{}{"key": "value"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	result := apply(t, h, `
+existing: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "existing", namespace: "default"}
+	data: key: string
+} @cuebectl(ref)
+copy: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "copy", namespace: "default"}
+	data: key: existing.data.key
+}
+`)
+	result.AssertApplied(t)
+	result.AssertStatus(t, reconcile.Referenced, "existing")
+	result.AssertStatus(t, reconcile.Applied, "copy")
+	result.AssertField(t, "value", "copy", "data", "key")
+}
+
+func TestApplyMutate(t *testing.T) {
+	h := newHarness(t)
+	h.Mutate(schema.GroupKind{Kind: "Service"}, func(u *unstructured.Unstructured) {
+		_ = unstructured.SetNestedField(u.Object, "10.0.0.1", "spec", "clusterIP")
+	})
+	result := apply(t, h, `
+svc: {
+	apiVersion: "v1"
+	kind:       "Service"
+	metadata: {name: "svc", namespace: "default"}
+	spec: {
+		ports: [{port: 80}]
+		clusterIP?: string
+	}
+}
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: serviceIP: svc.spec.clusterIP
+}
+`)
+	result.AssertApplied(t)
+	result.AssertField(t, "10.0.0.1", "config", "data", "serviceIP")
+}
+
+func TestApplyCustomResource(t *testing.T) {
+	h := newHarness(t, harness.Kind{
+		GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"},
+		Namespaced:       true,
+	})
+	result := apply(t, h, `
+widget: {
+	apiVersion: "example.com/v1"
+	kind:       "Widget"
+	metadata: {name: "widget", namespace: "default"}
+	spec: size: 3
+}
+`)
+	result.AssertApplied(t)
+	result.AssertField(t, int64(3), "widget", "spec", "size")
+}
+
+func TestApplyContext(t *testing.T) {
+	h := newHarness(t)
+	result := apply(t, h, `
+remote: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "remote", namespace: "default"}
+} @cuebectl(context="spoke")
+local: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "local", namespace: "default"}
+	data: remoteUID: remote.metadata.uid
+}
+`)
+	result.AssertApplied(t)
+	spoke, err := h.Context("spoke")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	remote, err := spoke.Resource(configMaps).Namespace("default").Get(context.Background(), "remote", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("remote was not applied to the spoke cluster: %v", err)
+	}
+	result.AssertField(t, string(remote.GetUID()), "local", "data", "remoteUID")
+	if _, err := h.Cluster.Resource(configMaps).Namespace("default").Get(context.Background(), "remote", metav1.GetOptions{}); err == nil {
+		t.Errorf("remote was applied to the default cluster")
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	h := newHarness(t)
+	result := apply(t, h, `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: key: "value"
+}
+`)
+
+	tests := []struct {
+		name   string
+		assert func(harness.T)
+		fails  bool
+	}{
+		{name: "applied", assert: func(t harness.T) { result.AssertApplied(t) }},
+		{name: "field", assert: func(t harness.T) { result.AssertField(t, "value", "config", "data", "key") }},
+		{name: "wrong field value", assert: func(t harness.T) { result.AssertField(t, "other", "config", "data", "key") }, fails: true},
+		{name: "missing field", assert: func(t harness.T) { result.AssertField(t, "value", "config", "data", "missing") }, fails: true},
+		{name: "missing path", assert: func(t harness.T) { result.AssertField(t, "value", "missing", "data", "key") }, fails: true},
+		{name: "status", assert: func(t harness.T) { result.AssertStatus(t, reconcile.Applied, "config") }},
+		{name: "wrong status", assert: func(t harness.T) { result.AssertStatus(t, reconcile.Referenced, "config") }, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			tt.assert(r)
+			if failed := len(r.errors) > 0; failed != tt.fails {
+				t.Errorf("got failures %q, want failure %v", r.errors, tt.fails)
+			}
+		})
+	}
+}
+
+func TestAssertAppliedNotConverged(t *testing.T) {
+	h := newHarness(t)
+	h.Timeout = time.Second
+	r := &cue.Runtime{}
+	instance, err := r.Compile("test.cue", `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: key: string
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.ApplyInstance(r, instance)
+	if err == nil {
+		t.Fatal("expected an instance that isn't concrete to not converge")
+	}
+	rec := &recorder{}
+	result.AssertApplied(rec)
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "config") {
+		t.Errorf("got failures %q, want one for config", rec.errors)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	h := newHarness(t)
+	result := apply(t, h, `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: key: "value"
+}
+`)
+	dir := filepath.Join(t.TempDir(), "golden")
+	if _, err := result.Golden(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := result.Golden(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatalf("got mismatches %v for updated golden files", mismatches)
+	}
+
+	file := harness.GoldenFile(dir, "config")
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, generated := range []string{"uid", "resourceVersion", "creationTimestamp", "cuebectl.io/source", "cuebectl.io/path"} {
+		if strings.Contains(string(b), generated) {
+			t.Errorf("golden file contains %s, which is left out of golden files:\n%s", generated, b)
+		}
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Replace(string(b), "value", "changed", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "removed.yaml"), []byte("kind: ConfigMap\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = result.Golden(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("got %d mismatches, want 2: %v", len(mismatches), mismatches)
+	}
+	for _, m := range mismatches {
+		switch m.File {
+		case file:
+			if m.Path != "config" || !strings.Contains(m.Diff, "-  key: changed") || !strings.Contains(m.Diff, "+  key: value") {
+				t.Errorf("unexpected mismatch for config: %+v", m)
+			}
+		case filepath.Join(dir, "removed.yaml"):
+			if m.Path != "" {
+				t.Errorf("got path %q for a golden file without a field", m.Path)
+			}
+		default:
+			t.Errorf("unexpected mismatch %+v", m)
+		}
+	}
+}
+
+func TestGoldenMasksSensitiveValues(t *testing.T) {
+	h := newHarness(t)
+	result := apply(t, h, `
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: key: "value"
+}
+token: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata: {name: "token", namespace: "default"}
+	stringData: token: "new-token"
+}
+`)
+	dir := filepath.Join(t.TempDir(), "golden")
+	if _, err := result.Golden(dir, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the golden files hold a value only they have, which is sensitive because it is in a Secret
+	for _, path := range []string{"config", "token"} {
+		file := harness.GoldenFile(dir, path)
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := strings.Replace(strings.Replace(string(b), "new-token", "old-token", 1), "key: value", "key: old-token", 1)
+		if err := ioutil.WriteFile(file, []byte(changed), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mismatches, err := result.Golden(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("got %d mismatches, want 2: %v", len(mismatches), mismatches)
+	}
+	for _, m := range mismatches {
+		if strings.Contains(m.Diff, "old-token") || strings.Contains(m.Diff, "new-token") {
+			t.Errorf("%s: diff shows a sensitive value:\n%s", m.Path, m.Diff)
+		}
+	}
+}
+
+// the example's golden files hold names generated by the cluster, which other fields reference, so they must be the
+// same on every run
+func TestGoldenExampleIsStable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "golden")
+	for run := 0; run < 2; run++ {
+		h := newHarness(t)
+		h.Options.Workers = 4
+		if err := h.Seed(&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": "default"},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := h.Apply("../../example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mismatches, err := result.Golden(dir, run == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range mismatches {
+			t.Errorf("run %d: %s doesn't match its golden file:\n%s", run, m.Path, m.Diff)
+		}
+	}
+}
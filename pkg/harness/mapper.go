@@ -0,0 +1,68 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package harness
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Kind is a kind of object that can be applied to the simulated cluster
+type Kind struct {
+	schema.GroupVersionKind
+
+	// Resource is the plural name of the resource. If empty, it is guessed from the kind.
+	Resource string
+
+	Namespaced bool
+}
+
+// BuiltinKinds are the kinds of a Kubernetes cluster that are known to NewRESTMapper
+var BuiltinKinds = []Kind{
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Node"}},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolume"}},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Endpoints"}, Resource: "endpoints", Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Service"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}, Resource: "ingresses", Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "NetworkPolicy"}, Resource: "networkpolicies", Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"}},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"}, Namespaced: true},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}, Resource: "storageclasses"},
+	{GroupVersionKind: schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}},
+}
+
+// NewRESTMapper returns a static RESTMapper for the built-in kinds and kinds, i.e. for custom resources
+func NewRESTMapper(kinds ...Kind) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, k := range append(append([]Kind{}, BuiltinKinds...), kinds...) {
+		scope := meta.RESTScopeRoot
+		if k.Namespaced {
+			scope = meta.RESTScopeNamespace
+		}
+		if k.Resource == "" {
+			mapper.Add(k.GroupVersionKind, scope)
+			continue
+		}
+		plural := k.GroupVersionKind.GroupVersion().WithResource(k.Resource)
+		singular := k.GroupVersionKind.GroupVersion().WithResource(strings.ToLower(k.Kind))
+		mapper.AddSpecific(k.GroupVersionKind, plural, singular, scope)
+	}
+	return mapper
+}
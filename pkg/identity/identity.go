@@ -4,10 +4,12 @@
 package identity
 
 import (
+	"strings"
+
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/util/workqueue"
 )
 
 // NamespacedGroupVersionResource is used to look up informers for resolved objects from the instance
@@ -22,6 +24,22 @@ type Locator struct {
 	Name string
 	// Path in instance
 	Path []string
+	// ReadOnly locators identify referenced objects that are read from the cluster, but never written
+	ReadOnly bool
+	// List locators identify all objects of the GVR in the namespace that match Selector, rather than a single
+	// object identified by Name
+	List     bool
+	Selector string
+	// Context is the kube context of the cluster the object(s) are in, or "" for the default cluster
+	Context string
+}
+
+// Reference identifies an existing object that is read from the cluster, by name or by label selector
+type Reference struct {
+	schema.GroupVersionKind
+	Namespace string
+	Name      string
+	Selector  string
 }
 
 type LocatedUnstructured struct {
@@ -29,37 +47,30 @@ type LocatedUnstructured struct {
 	*unstructured.Unstructured
 }
 
-// FilterFunc returns a function that can filter events to only react to objects identified by the locator
-func (l Locator) FilterFunc() func(o interface{}) bool {
-	return func(o interface{}) bool {
-		u, ok := o.(*unstructured.Unstructured)
-		if !ok {
+// Equal returns true if o identifies the same object(s) as l, for the same path
+func (l Locator) Equal(o Locator) bool {
+	return l.NamespacedGroupVersionResource == o.NamespacedGroupVersionResource && l.Name == o.Name &&
+		l.ReadOnly == o.ReadOnly && l.List == o.List && l.Selector == o.Selector && l.Context == o.Context &&
+		strings.Join(l.Path, "/") == strings.Join(o.Path, "/")
+}
+
+// Matches returns true if u is identified by the locator
+func (l Locator) Matches(u *unstructured.Unstructured) bool {
+	if l.List {
+		selector, err := labels.Parse(l.Selector)
+		if err != nil {
 			return false
 		}
-		return u.GetName() == l.Name && u.GetNamespace() == l.Namespace
+		return (l.Namespace == "" || u.GetNamespace() == l.Namespace) && selector.Matches(labels.Set(u.GetLabels()))
 	}
+	return u.GetName() == l.Name && u.GetNamespace() == l.Namespace
 }
 
-// EventHandler returns an event handler for this locator that adds a locator to the incoming object and adds to queue
-func (l Locator) EventHandler(queue workqueue.RateLimitingInterface) cache.ResourceEventHandler {
-	addToQueue := func(obj interface{}) {
-		queue.Add(&LocatedUnstructured{
-			Locator:      l,
-			Unstructured: obj.(*unstructured.Unstructured),
-		})
-	}
-	return cache.FilteringResourceEventHandler{
-		FilterFunc: l.FilterFunc(),
-		Handler: cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				addToQueue(obj)
-			},
-			UpdateFunc: func(oldObj, obj interface{}) {
-				addToQueue(obj)
-			},
-			DeleteFunc: func(obj interface{}) {
-				addToQueue(obj)
-			},
-		},
+// ToUnstructured unwraps objects from informer events, including the final state of deleted objects
+func ToUnstructured(o interface{}) (*unstructured.Unstructured, bool) {
+	if tombstone, ok := o.(cache.DeletedFinalStateUnknown); ok {
+		o = tombstone.Obj
 	}
+	u, ok := o.(*unstructured.Unstructured)
+	return u, ok
 }
@@ -0,0 +1,203 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+)
+
+// serverFields are populated by the apiserver (or by cuebectl) and are dropped on import
+var serverFields = [][]string{
+	{"status"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "deletionTimestamp"},
+	{"metadata", "deletionGracePeriodSeconds"},
+	{"metadata", "selfLink"},
+	{"metadata", "managedFields"},
+	{"metadata", "ownerReferences"},
+	{"metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"},
+	{"metadata", "annotations", ensure.ObjectHashKey},
+	{"metadata", "annotations", ensure.LastAppliedAnnotation},
+	{"metadata", "annotations", ensure.PathAnnotation},
+	{"metadata", "annotations", ensure.SourceAnnotation},
+	{"metadata", "labels", ensure.InstanceLabel},
+	{"metadata", "labels", ensure.ManagedByLabel},
+}
+
+// Options configure how objects are converted to cue
+type Options struct {
+	// Package is the name of the generated cue package
+	Package string
+
+	// Link replaces literal references to other imported objects (namespaces, roleRefs, service accounts, etc)
+	// with references to their fields, so that the imported package works with generated names.
+	Link bool
+}
+
+// Import converts objects into a cue file with one top-level field per object.
+func Import(objs []*unstructured.Unstructured, options Options) ([]byte, error) {
+	stripped := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, o := range objs {
+		stripped = append(stripped, Strip(o))
+	}
+
+	names := FieldNames(stripped)
+	var idx index
+	if options.Link {
+		idx = newIndex(stripped, names)
+	}
+
+	f := &ast.File{}
+	if options.Package != "" {
+		f.Decls = append(f.Decls, &ast.Package{Name: ast.NewIdent(options.Package)})
+	}
+	for i, o := range stripped {
+		var refs map[string]ast.Expr
+		if options.Link {
+			refs = idx.references(o)
+		}
+		f.Decls = append(f.Decls, &ast.Field{
+			Label: ast.NewIdent(names[i]),
+			Value: toExpr(o.Object, nil, refs),
+		})
+	}
+
+	b, err := format.Node(f, format.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Strip returns a copy of u without fields that are populated by the server, or by cuebectl to track the object.
+func Strip(u *unstructured.Unstructured) *unstructured.Unstructured {
+	out := u.DeepCopy()
+	for _, f := range serverFields {
+		unstructured.RemoveNestedField(out.Object, f...)
+	}
+	for _, m := range []string{"annotations", "labels"} {
+		if a, ok, _ := unstructured.NestedMap(out.Object, "metadata", m); ok && len(a) == 0 {
+			unstructured.RemoveNestedField(out.Object, "metadata", m)
+		}
+	}
+	return out
+}
+
+// FieldNames returns a unique cue identifier for each object, derived from its kind and name.
+func FieldNames(objs []*unstructured.Unstructured) []string {
+	names := make([]string, len(objs))
+	seen := map[string]int{}
+	for i, o := range objs {
+		name := o.GetName()
+		if name == "" {
+			name = o.GetGenerateName()
+		}
+		n := identifier(o.GetKind() + "-" + name)
+		if _, ok := seen[n]; ok && o.GetNamespace() != "" {
+			n = identifier(o.GetKind() + "-" + o.GetNamespace() + "-" + name)
+		}
+		seen[n]++
+		if seen[n] > 1 {
+			n = fmt.Sprintf("%s%d", n, seen[n])
+		}
+		names[i] = n
+	}
+	return names
+}
+
+// identifier camel-cases s, dropping characters that aren't valid in an identifier
+func identifier(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit(rune(id[0])) {
+		id = "Object" + id
+	}
+	return id
+}
+
+func toExpr(v interface{}, path []string, refs map[string]ast.Expr) ast.Expr {
+	if ref, ok := refs[strings.Join(path, "/")]; ok {
+		return ref
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		fields := make([]interface{}, 0, 2*len(x))
+		for _, k := range sortedKeys(x) {
+			fields = append(fields, label(k), toExpr(x[k], append(path, k), refs))
+		}
+		return ast.NewStruct(fields...)
+	case []interface{}:
+		elems := make([]ast.Expr, 0, len(x))
+		for i, e := range x {
+			elems = append(elems, toExpr(e, append(path, strconv.Itoa(i)), refs))
+		}
+		return ast.NewList(elems...)
+	case string:
+		return ast.NewString(x)
+	case bool:
+		return ast.NewBool(x)
+	case int64:
+		return ast.NewLit(token.INT, strconv.FormatInt(x, 10))
+	case int:
+		return ast.NewLit(token.INT, strconv.Itoa(x))
+	case float64:
+		return ast.NewLit(token.FLOAT, strconv.FormatFloat(x, 'g', -1, 64))
+	case nil:
+		return ast.NewNull()
+	default:
+		return ast.NewString(fmt.Sprint(x))
+	}
+}
+
+func label(k string) ast.Label {
+	if ast.IsValidIdent(k) && !strings.HasPrefix(k, "_") && !strings.HasPrefix(k, "#") {
+		return ast.NewIdent(k)
+	}
+	return ast.NewString(k)
+}
+
+// sortedKeys orders type and object metadata first, followed by the remaining keys in lexical order
+func sortedKeys(m map[string]interface{}) []string {
+	first := []string{"apiVersion", "kind", "metadata"}
+	keys := make([]string, 0, len(m))
+	for _, k := range first {
+		if _, ok := m[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	rest := make([]string, 0, len(m))
+	for k := range m {
+		if k != "apiVersion" && k != "kind" && k != "metadata" {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package importer_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/importer"
+)
+
+func object(kind, namespace, name string, fields map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": kind}}
+	for k, v := range fields {
+		u.Object[k] = v
+	}
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func TestStrip(t *testing.T) {
+	managed := object("ConfigMap", "default", "config", map[string]interface{}{"data": map[string]interface{}{"key": "value"}})
+	managed.SetUID("1234")
+	managed.SetResourceVersion("5")
+	managed.SetGeneration(2)
+	managed.SetLabels(map[string]string{"app": "test"})
+	ensure.Stamp(managed, "test", ensure.Options{Path: []string{"config"}, Source: "app.cue:1:1"})
+	if err := ensure.HashUnstructured(managed); err != nil {
+		t.Fatal(err)
+	}
+	managed.Object["status"] = map[string]interface{}{"phase": "Active"}
+
+	onlyCuebectl := managed.DeepCopy()
+	onlyCuebectl.SetLabels(map[string]string{ensure.InstanceLabel: "test", ensure.ManagedByLabel: "cuebectl"})
+
+	tests := []struct {
+		name    string
+		in      *unstructured.Unstructured
+		removed [][]string
+		kept    [][]string
+	}{
+		{
+			name: "server fields",
+			in:   managed,
+			removed: [][]string{
+				{"status"},
+				{"metadata", "uid"},
+				{"metadata", "resourceVersion"},
+				{"metadata", "generation"},
+			},
+			kept: [][]string{{"metadata", "name"}, {"metadata", "namespace"}, {"data", "key"}},
+		},
+		{
+			name: "cuebectl labels and annotations",
+			in:   managed,
+			removed: [][]string{
+				{"metadata", "labels", ensure.InstanceLabel},
+				{"metadata", "labels", ensure.ManagedByLabel},
+				{"metadata", "annotations"},
+			},
+			kept: [][]string{{"metadata", "labels", "app"}},
+		},
+		{
+			name:    "empty labels",
+			in:      onlyCuebectl,
+			removed: [][]string{{"metadata", "labels"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := importer.Strip(tt.in)
+			for _, path := range tt.removed {
+				if _, ok, _ := unstructured.NestedFieldNoCopy(out.Object, path...); ok {
+					t.Errorf("%s was not removed", strings.Join(path, "."))
+				}
+			}
+			for _, path := range tt.kept {
+				if _, ok, _ := unstructured.NestedFieldNoCopy(out.Object, path...); !ok {
+					t.Errorf("%s was removed", strings.Join(path, "."))
+				}
+			}
+			if _, ok, _ := unstructured.NestedFieldNoCopy(tt.in.Object, "metadata", "uid"); !ok {
+				t.Error("the object passed in was modified")
+			}
+		})
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	generated := object("ConfigMap", "default", "", nil)
+	generated.SetGenerateName("test-")
+	tests := []struct {
+		name string
+		objs []*unstructured.Unstructured
+		want []string
+	}{
+		{
+			name: "kind and name",
+			objs: []*unstructured.Unstructured{object("ConfigMap", "default", "app-config", nil), object("Namespace", "", "test", nil)},
+			want: []string{"ConfigMapAppConfig", "NamespaceTest"},
+		},
+		{
+			name: "same name in another namespace",
+			objs: []*unstructured.Unstructured{object("ConfigMap", "a", "config", nil), object("ConfigMap", "b", "config", nil)},
+			want: []string{"ConfigMapConfig", "ConfigMapBConfig"},
+		},
+		{
+			name: "duplicates",
+			objs: []*unstructured.Unstructured{object("ConfigMap", "", "config", nil), object("ConfigMap", "", "config", nil)},
+			want: []string{"ConfigMapConfig", "ConfigMapConfig2"},
+		},
+		{
+			name: "generated name",
+			objs: []*unstructured.Unstructured{generated},
+			want: []string{"ConfigMapTest"},
+		},
+		{
+			name: "leading digit",
+			objs: []*unstructured.Unstructured{object("", "", "1st", nil)},
+			want: []string{"Object1st"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := importer.FieldNames(tt.objs); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	role := object("Role", "test", "reader", map[string]interface{}{"apiVersion": "rbac.authorization.k8s.io/v1"})
+	binding := object("RoleBinding", "test", "reader", map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"roleRef":    map[string]interface{}{"apiGroup": "rbac.authorization.k8s.io", "kind": "Role", "name": "reader"},
+		"subjects": []interface{}{
+			map[string]interface{}{"kind": "ServiceAccount", "name": "app", "namespace": "test"},
+		},
+	})
+	deployment := object("Deployment", "test", "app", map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"serviceAccountName": "app",
+			"volumes": []interface{}{
+				map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "config"}},
+			},
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "envFrom": []interface{}{
+					map[string]interface{}{"secretRef": map[string]interface{}{"name": "missing"}},
+				}},
+			},
+		}}},
+	})
+	objs := []*unstructured.Unstructured{
+		object("Namespace", "", "test", nil),
+		object("ServiceAccount", "test", "app", nil),
+		object("ConfigMap", "test", "config", map[string]interface{}{"data": map[string]interface{}{"app.properties": "x=1"}}),
+		role,
+		binding,
+		deployment,
+	}
+
+	tests := []struct {
+		name     string
+		options  importer.Options
+		contains []string
+		values   map[string]string
+	}{
+		{
+			name:    "literal",
+			options: importer.Options{Package: "app"},
+			contains: []string{
+				"package app",
+				`"app.properties": "x=1"`,
+				"RoleBindingReader: {",
+			},
+			values: map[string]string{"RoleBindingReader.roleRef.name": "reader"},
+		},
+		{
+			name:    "linked",
+			options: importer.Options{Link: true},
+			contains: []string{
+				"namespace: NamespaceTest.metadata.name",
+				"name: RoleReader.metadata.name",
+				"serviceAccountName: ServiceAccountApp.metadata.name",
+				"name: ConfigMapConfig.metadata.name",
+				`name: "missing"`,
+			},
+			values: map[string]string{
+				"RoleBindingReader.roleRef.name":                                           "reader",
+				"RoleBindingReader.subjects[0].name":                                       "app",
+				"DeploymentApp.spec.template.spec.volumes[0].configMap.name":               "config",
+				"DeploymentApp.spec.template.spec.serviceAccountName":                      "app",
+				"DeploymentApp.spec.template.spec.containers[0].envFrom[0].secretRef.name": "missing",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := importer.Import(objs, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// fields are aligned by the formatter, which isn't what is being tested
+			out := strings.Join(strings.Fields(string(b)), " ")
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, b)
+				}
+			}
+			r := &cue.Runtime{}
+			if _, err := r.Compile("import.cue", b); err != nil {
+				t.Fatalf("output doesn't compile: %v\n%s", err, b)
+			}
+			for expr, want := range tt.values {
+				// evaluate expr next to the imported fields, so that references between them are followed
+				instance, err := r.Compile("import.cue", string(b)+"\nresult: "+expr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := instance.Lookup("result").String(); err != nil || got != want {
+					t.Errorf("%s: got %q (%v), want %q", expr, got, err, want)
+				}
+			}
+		})
+	}
+}
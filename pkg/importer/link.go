@@ -0,0 +1,149 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package importer
+
+import (
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue/ast"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// target identifies an object that can be referenced by other objects
+type target struct {
+	kind, namespace, name string
+}
+
+// index maps imported objects to the fields they were imported into
+type index map[target]string
+
+func newIndex(objs []*unstructured.Unstructured, names []string) index {
+	idx := index{}
+	for i, o := range objs {
+		if o.GetName() == "" {
+			continue
+		}
+		idx[target{kind: o.GetKind(), namespace: o.GetNamespace(), name: o.GetName()}] = names[i]
+	}
+	return idx
+}
+
+// podSpecPaths are the locations of pod specs in well-known kinds
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// references returns cue references for literal values in u that name other imported objects, keyed by the
+// slash-separated path of the literal in u.
+func (idx index) references(u *unstructured.Unstructured) map[string]ast.Expr {
+	refs := map[string]ast.Expr{}
+	ns := u.GetNamespace()
+
+	idx.link(refs, target{kind: "Namespace", name: ns}, "metadata", "namespace")
+
+	switch u.GetKind() {
+	case "RoleBinding", "ClusterRoleBinding":
+		kind, _, _ := unstructured.NestedString(u.Object, "roleRef", "kind")
+		name, _, _ := unstructured.NestedString(u.Object, "roleRef", "name")
+		roleNs := ns
+		if kind == "ClusterRole" {
+			roleNs = ""
+		}
+		idx.link(refs, target{kind: kind, namespace: roleNs, name: name}, "roleRef", "name")
+
+		subjects, _, _ := unstructured.NestedSlice(u.Object, "subjects")
+		for i, s := range subjects {
+			subject, ok := s.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			kind, _ := subject["kind"].(string)
+			name, _ := subject["name"].(string)
+			subjectNs, _ := subject["namespace"].(string)
+			if kind == "ServiceAccount" {
+				idx.link(refs, target{kind: kind, namespace: subjectNs, name: name}, "subjects", strconv.Itoa(i), "name")
+			}
+			idx.link(refs, target{kind: "Namespace", name: subjectNs}, "subjects", strconv.Itoa(i), "namespace")
+		}
+	}
+
+	if path, ok := podSpecPaths[u.GetKind()]; ok {
+		if spec, ok, _ := unstructured.NestedMap(u.Object, path...); ok {
+			idx.linkPodSpec(refs, ns, spec, path)
+		}
+	}
+	return refs
+}
+
+func (idx index) linkPodSpec(refs map[string]ast.Expr, ns string, spec map[string]interface{}, path []string) {
+	at := func(p ...string) []string {
+		return append(append([]string{}, path...), p...)
+	}
+
+	if sa, ok := spec["serviceAccountName"].(string); ok {
+		idx.link(refs, target{kind: "ServiceAccount", namespace: ns, name: sa}, at("serviceAccountName")...)
+	}
+
+	volumes, _ := spec["volumes"].([]interface{})
+	for i, v := range volumes {
+		volume, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		vi := strconv.Itoa(i)
+		if name, ok, _ := unstructured.NestedString(volume, "configMap", "name"); ok {
+			idx.link(refs, target{kind: "ConfigMap", namespace: ns, name: name}, at("volumes", vi, "configMap", "name")...)
+		}
+		if name, ok, _ := unstructured.NestedString(volume, "secret", "secretName"); ok {
+			idx.link(refs, target{kind: "Secret", namespace: ns, name: name}, at("volumes", vi, "secret", "secretName")...)
+		}
+		if name, ok, _ := unstructured.NestedString(volume, "persistentVolumeClaim", "claimName"); ok {
+			idx.link(refs, target{kind: "PersistentVolumeClaim", namespace: ns, name: name}, at("volumes", vi, "persistentVolumeClaim", "claimName")...)
+		}
+	}
+
+	for _, containers := range []string{"initContainers", "containers"} {
+		list, _ := spec[containers].([]interface{})
+		for i, c := range list {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			envFrom, _ := container["envFrom"].([]interface{})
+			for j, e := range envFrom {
+				source, ok := e.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				ci, ej := strconv.Itoa(i), strconv.Itoa(j)
+				if name, ok, _ := unstructured.NestedString(source, "configMapRef", "name"); ok {
+					idx.link(refs, target{kind: "ConfigMap", namespace: ns, name: name}, at(containers, ci, "envFrom", ej, "configMapRef", "name")...)
+				}
+				if name, ok, _ := unstructured.NestedString(source, "secretRef", "name"); ok {
+					idx.link(refs, target{kind: "Secret", namespace: ns, name: name}, at(containers, ci, "envFrom", ej, "secretRef", "name")...)
+				}
+			}
+		}
+	}
+}
+
+// link records a reference to t's name at path, if t was imported
+func (idx index) link(refs map[string]ast.Expr, t target, path ...string) {
+	if t.name == "" {
+		return
+	}
+	field, ok := idx[t]
+	if !ok {
+		return
+	}
+	refs[strings.Join(path, "/")] = ast.NewSel(ast.NewIdent(field), "metadata", "name")
+}
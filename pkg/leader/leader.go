@@ -0,0 +1,149 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Config configures leader election with a Lease lock
+type Config struct {
+	// Namespace and Name of the Lease used as the lock
+	Namespace string
+	Name      string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// NewConfig returns a Config for the lease name/namespace with default durations
+func NewConfig(namespace, name string) Config {
+	return Config{
+		Namespace:     namespace,
+		Name:          name,
+		LeaseDuration: DefaultLeaseDuration,
+		RenewDeadline: DefaultRenewDeadline,
+		RetryPeriod:   DefaultRetryPeriod,
+	}
+}
+
+// Run blocks until the lease is acquired, and then calls run with a context that is cancelled when leadership is
+// lost or ctx is done. The lease is released once run returns, so run must not return until its work has stopped;
+// another candidate can then take over immediately. Run returns an error if leadership was lost before ctx was done.
+func Run(ctx context.Context, client kubernetes.Interface, config Config, run func(ctx context.Context)) error {
+	id, err := identity()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: config.Namespace,
+			Name:      config.Name,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+
+	leading, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// completed is set if run returned while still leading, as opposed to because leadership was lost
+	var started int32
+	var completed bool
+	done := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		// the elector would release the lease as soon as leading is done, while run may still be writing
+		ReleaseOnCancel: false,
+		Name:            config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				atomic.StoreInt32(&started, 1)
+				defer close(done)
+				klog.Infof("%s acquired lease %s/%s", id, config.Namespace, config.Name)
+				run(leaderCtx)
+				completed = leaderCtx.Err() == nil
+				// stop renewing as soon as the work is done
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				klog.Infof("%s stopped leading %s/%s", id, config.Namespace, config.Name)
+			},
+			OnNewLeader: func(leader string) {
+				if leader == id {
+					return
+				}
+				klog.Infof("waiting for lease %s/%s, currently held by %s", config.Namespace, config.Name, leader)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	elector.Run(leading)
+
+	// the lease can only fail to be acquired if ctx is done
+	if ctx.Err() != nil && atomic.LoadInt32(&started) == 0 {
+		return nil
+	}
+	<-done
+	if !completed && ctx.Err() == nil {
+		return fmt.Errorf("lost lease %s/%s", config.Namespace, config.Name)
+	}
+	if err := release(lock, id); err != nil {
+		klog.Errorf("could not release lease %s/%s: %v", config.Namespace, config.Name, err)
+	}
+	return nil
+}
+
+// release gives up the lease, if it is still held by id, so that another candidate can acquire it without waiting
+// for it to expire
+func release(lock resourcelock.Interface, id string) error {
+	record, _, err := lock.Get(context.TODO())
+	if err != nil {
+		return err
+	}
+	if record.HolderIdentity != id {
+		return nil
+	}
+	now := metav1.Now()
+	return lock.Update(context.TODO(), resourcelock.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		RenewTime:            now,
+		AcquireTime:          now,
+		LeaderTransitions:    record.LeaderTransitions,
+	})
+}
+
+func identity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return hostname + "_" + rand.String(8), nil
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package leader_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/cuebernetes/cuebectl/pkg/leader"
+)
+
+func TestLeaseHeldUntilRunReturns(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := leader.NewConfig("default", "test")
+	config.RetryPeriod = 100 * time.Millisecond
+
+	holder := func() string {
+		lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+		return *lease.Spec.HolderIdentity
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var heldWhileStopping string
+	err := leader.Run(ctx, client, config, func(leaderCtx context.Context) {
+		cancel()
+		<-leaderCtx.Done()
+		// the work takes a while to stop, and the lease must not be released before it has
+		time.Sleep(3 * config.RetryPeriod)
+		heldWhileStopping = holder()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heldWhileStopping == "" {
+		t.Error("lease was released before run returned")
+	}
+	if h := holder(); h != "" {
+		t.Errorf("lease is still held by %s after run returned", h)
+	}
+}
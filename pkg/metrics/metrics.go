@@ -0,0 +1,78 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const namespace = "cuebectl"
+
+// Registry holds all cuebectl metrics. It is separate from the default registry so that only metrics registered here
+// (and the standard process and go collectors) are served.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Syncs counts syncs of labels with the cluster, by label, kind of object, and result
+	Syncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_total",
+		Help:      "Number of syncs of instance labels with the cluster.",
+	}, []string{"label", "group", "version", "kind", "result"})
+
+	// SyncDuration observes the time it takes to sync a label with the cluster
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Time taken to sync an instance label with the cluster.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"label", "group", "version", "kind"})
+
+	// UnificationDuration observes the time it takes to unify the instance with the cluster state
+	UnificationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "unification_duration_seconds",
+		Help:      "Time taken to unify the instance with the cluster state.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
+	// NotConcrete counts lookups of labels that weren't yet concrete
+	NotConcrete = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "not_concrete_total",
+		Help:      "Number of lookups of instance labels that were not yet concrete.",
+	}, []string{"label"})
+
+	// Informers is the number of running informers
+	Informers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "informers",
+		Help:      "Number of running informers.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+		Syncs,
+		SyncDuration,
+		UnificationDuration,
+		NotConcrete,
+		Informers,
+	)
+}
+
+// ObserveSync records a sync of label, producing an object of kind gvk (which may be empty if the lookup failed)
+func ObserveSync(label string, gvk schema.GroupVersionKind, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	Syncs.WithLabelValues(label, gvk.Group, gvk.Version, gvk.Kind, result).Inc()
+	SyncDuration.WithLabelValues(label, gvk.Group, gvk.Version, gvk.Kind).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,353 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cuebernetes/cuebectl/pkg/apply"
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+	"github.com/cuebernetes/cuebectl/pkg/facts"
+	"github.com/cuebernetes/cuebectl/pkg/redact"
+)
+
+// CueInstanceGVR identifies the CueInstance custom resource
+var CueInstanceGVR = schema.GroupVersionResource{Group: "cuebectl.io", Version: "v1alpha1", Resource: "cueinstances"}
+
+// Operator reconciles CueInstance custom resources, running one CueInstanceController for each
+type Operator struct {
+	client    dynamic.Interface
+	mapper    meta.RESTMapper
+	discovery discovery.DiscoveryInterface
+	options   Options
+
+	informer informers.GenericInformer
+	queue    workqueue.RateLimitingInterface
+
+	// running instances, keyed by namespace/name of the CueInstance
+	running map[string]*running
+	sync.Mutex
+}
+
+// running is a CueInstanceController started for a specific generation of a CueInstance and version of its source
+type running struct {
+	key     string
+	cancel  context.CancelFunc
+	done    chan struct{}
+	cleanup string
+}
+
+// Options configure an Operator
+type Options struct {
+	// Namespace of the CueInstances to reconcile, or all namespaces if empty
+	Namespace string
+
+	// ResyncPeriod is how often the source of each CueInstance is re-read, so that changes to referenced ConfigMaps
+	// and paths are picked up
+	ResyncPeriod time.Duration
+
+	// Workers is the number of workers of each instance controller
+	Workers int
+
+	// Recorder records events for the objects of each instance, with the CueInstance as inventory object. If nil, no
+	// events are recorded.
+	Recorder record.EventRecorder
+
+	// Impersonate returns a client that impersonates a user, for fields with @cuebectl(serviceAccount=...). If nil,
+	// those fields fail.
+	Impersonate ensure.Impersonator
+
+	// AllowCrossNamespace lets CueInstances apply and reference cluster-scoped objects, and objects in other
+	// namespaces. Otherwise, each CueInstance is confined to its own namespace.
+	AllowCrossNamespace bool
+
+	// SourceRoot is the directory that spec.source.path must be in. If empty, spec.source.path is disabled.
+	SourceRoot string
+
+	// ShowSecrets leaves Secret payloads, and fields marked with @cuebectl(sensitive), unmasked in the status of
+	// CueInstances, in events and in logs
+	ShowSecrets bool
+
+	// Ready is called once the CueInstances have been listed, i.e. to report readiness
+	Ready func()
+}
+
+// NewOperator returns an operator for CueInstances
+func NewOperator(client dynamic.Interface, mapper meta.RESTMapper, discovery discovery.DiscoveryInterface, options Options) *Operator {
+	return &Operator{
+		client:    client,
+		mapper:    mapper,
+		discovery: discovery,
+		options:   options,
+		informer:  dynamicinformer.NewFilteredDynamicInformer(client, CueInstanceGVR, options.Namespace, options.ResyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil),
+		queue:     workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		running:   map[string]*running{},
+	}
+}
+
+// Run processes CueInstances until ctx is done, and then stops all running instances.
+func (o *Operator) Run(ctx context.Context) error {
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			klog.V(1).Error(err, "could not key object")
+			return
+		}
+		o.queue.Add(key)
+	}
+	o.informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(oldObj, obj interface{}) {
+			old, ok := oldObj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			// ignore status updates, but not resyncs
+			if old.GetGeneration() == u.GetGeneration() && old.GetResourceVersion() != u.GetResourceVersion() {
+				return
+			}
+			enqueue(obj)
+		},
+		DeleteFunc: enqueue,
+	})
+	go o.informer.Informer().Run(ctx.Done())
+	if o.options.Ready != nil {
+		go func() {
+			if cache.WaitForCacheSync(ctx.Done(), o.informer.Informer().HasSynced) {
+				o.options.Ready()
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		o.queue.ShutDown()
+	}()
+
+	for o.processNext(ctx) {
+	}
+
+	o.stopAll()
+	return nil
+}
+
+func (o *Operator) processNext(ctx context.Context) bool {
+	item, shutdown := o.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer o.queue.Done(item)
+
+	key, ok := item.(string)
+	if !ok {
+		o.queue.Forget(item)
+		return true
+	}
+	if err := o.reconcile(ctx, key); err != nil {
+		klog.V(1).Error(err, "could not reconcile", "key", key)
+		o.queue.AddRateLimited(key)
+		return true
+	}
+	o.queue.Forget(key)
+	return true
+}
+
+// reconcile starts, restarts, or stops the instance for a CueInstance so that it runs the current source
+func (o *Operator) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, err := o.informer.Lister().ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		o.stop(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cr, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+
+	src, err := readSource(ctx, o.client, cr, o.options.SourceRoot)
+	if err != nil {
+		o.setFailed(ctx, cr, "InvalidSource", err)
+		return err
+	}
+	hash := src.hash()
+	restartKey := restartKey(cr, hash)
+
+	o.Lock()
+	current, ok := o.running[key]
+	o.Unlock()
+	if ok && current.key == restartKey {
+		return nil
+	}
+	o.stop(key)
+	return o.start(ctx, key, cr, src, hash, restartKey)
+}
+
+// restartKey identifies what a running instance was started from: the generation of the CueInstance, the options in
+// its spec, and the hash of its source, which can change without a new generation
+func restartKey(cr *unstructured.Unstructured, hash string) string {
+	adopt, _, _ := unstructured.NestedBool(cr.Object, "spec", "adopt")
+	forceAdopt, _, _ := unstructured.NestedBool(cr.Object, "spec", "forceAdopt")
+	prune, _, _ := unstructured.NestedBool(cr.Object, "spec", "prune")
+	return fmt.Sprintf("%d/%t/%t/%t/%s", cr.GetGeneration(), adopt, forceAdopt, prune, hash)
+}
+
+func (o *Operator) start(ctx context.Context, key string, cr *unstructured.Unstructured, src *source, hash, restartKey string) error {
+	dir, cleanup, err := src.materialize()
+	if err != nil {
+		o.setFailed(ctx, cr, "InvalidSource", err)
+		return err
+	}
+	cluster, err := facts.Discover(o.discovery, "", cr.GetNamespace())
+	if err != nil {
+		removeAll(cleanup)
+		return err
+	}
+	r, _, instance, err := apply.Load(dir, cluster)
+	if err != nil {
+		removeAll(cleanup)
+		o.setFailed(ctx, cr, "InvalidSource", err)
+		// invalid cue won't become valid without a change to the source
+		return nil
+	}
+	// once the instance is loaded its sensitive values are known, and are masked in later failures
+	var redactor *redact.Redactor
+	if !o.options.ShowSecrets {
+		redactor = redact.New(instance.Value())
+	}
+
+	adopt, _, _ := unstructured.NestedBool(cr.Object, "spec", "adopt")
+	forceAdopt, _, _ := unstructured.NestedBool(cr.Object, "spec", "forceAdopt")
+	prune, _, _ := unstructured.NestedBool(cr.Object, "spec", "prune")
+	options := controller.Options{
+		Name:        InstanceName(cr),
+		Adopt:       adopt,
+		ForceAdopt:  forceAdopt,
+		Prune:       prune,
+		Workers:     o.options.Workers,
+		Impersonate: o.options.Impersonate,
+		ShowSecrets: o.options.ShowSecrets,
+
+		// a CueInstance may only act as the service accounts in its own namespace
+		ServiceAccountNamespace: cr.GetNamespace(),
+	}
+	if !o.options.AllowCrossNamespace {
+		options.Namespace = cr.GetNamespace()
+	}
+	if o.options.Recorder != nil {
+		options.Recorder = events.NewRecorder(o.options.Recorder, cr)
+	}
+	c, err := controller.NewCueInstanceController(o.client, o.mapper, r, instance, options)
+	if err != nil {
+		removeAll(cleanup)
+		o.setFailed(ctx, cr, "InvalidSource", redactor.Error(err))
+		return nil
+	}
+
+	instanceCtx, cancel := context.WithCancel(ctx)
+	run := &running{key: restartKey, cancel: cancel, done: make(chan struct{}), cleanup: cleanup}
+	events := make(chan controller.Event, controller.EventBufferSize)
+	if _, err := c.Start(instanceCtx, events); err != nil {
+		cancel()
+		c.Wait()
+		removeAll(cleanup)
+		o.setFailed(ctx, cr, "InvalidSource", redactor.Error(err))
+		return nil
+	}
+
+	o.Lock()
+	o.running[key] = run
+	o.Unlock()
+
+	klog.Infof("started %s", key)
+	go func() {
+		defer close(run.done)
+		newStatusWriter(o.client, cr, hash).run(instanceCtx, events)
+		// the replacement for the instance must not run until its workers have stopped writing objects
+		c.Wait()
+	}()
+	return nil
+}
+
+// stop cancels the instance running for key, if any, and waits for its status writer and workers to finish
+func (o *Operator) stop(key string) {
+	o.Lock()
+	run, ok := o.running[key]
+	delete(o.running, key)
+	o.Unlock()
+	if !ok {
+		return
+	}
+	run.cancel()
+	<-run.done
+	removeAll(run.cleanup)
+	klog.Infof("stopped %s", key)
+}
+
+func (o *Operator) stopAll() {
+	o.Lock()
+	keys := make([]string, 0, len(o.running))
+	for key := range o.running {
+		keys = append(keys, key)
+	}
+	o.Unlock()
+	for _, key := range keys {
+		o.stop(key)
+	}
+}
+
+// setFailed reports err in the status of cr as the reason its instance isn't running. Sensitive values must already be
+// masked in err.
+func (o *Operator) setFailed(ctx context.Context, cr *unstructured.Unstructured, reason string, err error) {
+	if statusErr := updateStatus(ctx, o.client, cr, func(status map[string]interface{}) {
+		status["observedGeneration"] = cr.GetGeneration()
+		setCondition(status, conditionReady, "False", reason, err.Error())
+		status["lastErrors"] = []interface{}{err.Error()}
+	}); statusErr != nil {
+		klog.V(1).Error(statusErr, "could not update status")
+	}
+}
+
+// InstanceName is the name of the instance for a CueInstance, used to label the objects it manages
+func InstanceName(cr *unstructured.Unstructured) string {
+	return ensure.InstanceName(cr.GetNamespace() + "." + cr.GetName())
+}
+
+func removeAll(dir string) {
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		klog.V(1).Error(err, "could not remove source", "dir", dir)
+	}
+}
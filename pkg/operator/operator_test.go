@@ -0,0 +1,142 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package operator_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/operator"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func cueInstance(namespace, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cuebectl.io/v1alpha1",
+		"kind":       "CueInstance",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace, "uid": namespace + "-" + name, "generation": int64(1)},
+		"spec": map[string]interface{}{
+			"source": map[string]interface{}{"inline": map[string]interface{}{"app.cue": fmt.Sprintf(`package app
+
+import "list"
+
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: %q}
+	data: instance: %q
+}
+for i in list.Range(0, 10, 1) {
+	"copy-\(i)": {
+		apiVersion: "v1"
+		kind:       "ConfigMap"
+		metadata: {name: "copy-\(i)", namespace: %q}
+		data: uid: config.metadata.uid
+	}
+}
+`, namespace, name, namespace)}},
+		},
+	}}
+}
+
+// start runs an operator for the CueInstances in client until the test ends
+func start(t *testing.T, client *simulate.Cluster) {
+	t.Helper()
+	discovery := &fakediscovery.FakeDiscovery{
+		Fake:               &k8stesting.Fake{},
+		FakedServerVersion: &version.Info{Major: "1", Minor: "19", GitVersion: "v1.19.2"},
+	}
+	o := operator.NewOperator(client, harness.NewRESTMapper(), discovery, operator.Options{Workers: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := o.Run(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitFor polls until condition is true
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	if err := wait.PollImmediate(50*time.Millisecond, 15*time.Second, func() (bool, error) {
+		return condition(), nil
+	}); err != nil {
+		t.Fatalf("timed out waiting for %s", description)
+	}
+}
+
+func exists(client *simulate.Cluster, namespace, name string) func() bool {
+	return func() bool {
+		_, err := client.Resource(configMaps).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		return err == nil
+	}
+}
+
+// instances run concurrently, and share cue's global state, so this is mostly a test for -race
+func TestConcurrentInstances(t *testing.T) {
+	namespaces := []string{"a", "b", "c", "d"}
+	var objects []runtime.Object
+	for _, namespace := range namespaces {
+		objects = append(objects, cueInstance(namespace, "app"))
+	}
+	client, err := simulate.NewCluster(nil, objects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, client)
+	for _, namespace := range namespaces {
+		waitFor(t, namespace+"/copy-9", exists(client, namespace, "copy-9"))
+	}
+}
+
+func TestRestartOnSpecChange(t *testing.T) {
+	client, err := simulate.NewCluster(nil, cueInstance("a", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, client)
+	waitFor(t, "a/copy-9", exists(client, "a", "copy-9"))
+
+	// changing only the options in the spec restarts the instance, which reports the new generation as observed
+	instances := client.Resource(operator.CueInstanceGVR).Namespace("a")
+	cr, err := instances.Get(context.Background(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(cr.Object, true, "spec", "prune"); err != nil {
+		t.Fatal(err)
+	}
+	cr.SetGeneration(2)
+	if _, err := instances.Update(context.Background(), cr, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "observedGeneration 2", func() bool {
+		cr, err := instances.Get(context.Background(), "app", metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		observed, _, _ := unstructured.NestedInt64(cr.Object, "status", "observedGeneration")
+		return observed == 2
+	})
+}
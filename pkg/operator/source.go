@@ -0,0 +1,192 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var configMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// source is the set of cue files for a CueInstance, keyed by path relative to the instance root
+type source struct {
+	// dir is set for sources that are already on disk
+	dir   string
+	files map[string]string
+}
+
+// readSource reads the files referenced by spec.source of a CueInstance. Exactly one of inline, configMap, or path
+// must be set. Paths must be below root, and are disabled if root is empty.
+func readSource(ctx context.Context, client dynamic.Interface, cr *unstructured.Unstructured, root string) (*source, error) {
+	inline, hasInline, err := unstructured.NestedStringMap(cr.Object, "spec", "source", "inline")
+	if err != nil {
+		return nil, err
+	}
+	configMap, hasConfigMap, err := unstructured.NestedString(cr.Object, "spec", "source", "configMap", "name")
+	if err != nil {
+		return nil, err
+	}
+	path, hasPath, err := unstructured.NestedString(cr.Object, "spec", "source", "path")
+	if err != nil {
+		return nil, err
+	}
+
+	set := 0
+	for _, has := range []bool{hasInline, hasConfigMap, hasPath} {
+		if has {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of spec.source.inline, spec.source.configMap, or spec.source.path must be set")
+	}
+
+	switch {
+	case hasInline:
+		return &source{files: inline}, nil
+	case hasConfigMap:
+		cm, err := client.Resource(configMapGVR).Namespace(cr.GetNamespace()).Get(ctx, configMap, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, _, err := unstructured.NestedStringMap(cm.Object, "data")
+		if err != nil {
+			return nil, err
+		}
+		return &source{files: data}, nil
+	default:
+		dir, err := resolvePath(root, path)
+		if err != nil {
+			return nil, err
+		}
+		files, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &source{dir: dir, files: files}, nil
+	}
+}
+
+// resolvePath resolves path, relative to root unless absolute, and checks that it doesn't leave root
+func resolvePath(root, path string) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("spec.source.path is disabled, the controller has no --source-root")
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("spec.source.path %q is outside of the source root %s", path, root)
+	}
+	return resolved, nil
+}
+
+// within returns true if path is root, or below it. Both must be clean and free of symlinks.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readDir reads all files below dir, following symlinks to files (as used by mounted volumes) as long as they stay
+// within dir
+func readDir(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// kubelet stores mounted configmap data in hidden, timestamped directories, linked from ..data
+		if path != dir && strings.HasPrefix(info.Name(), "..") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if !within(dir, target) {
+			return fmt.Errorf("%s links outside of %s", path, dir)
+		}
+		b, err := ioutil.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		files[rel] = string(b)
+		return nil
+	})
+	return files, err
+}
+
+// hash identifies the contents of the source, so that changes can be detected
+func (s *source) hash() string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, s.files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// materialize returns a directory containing the source. Sources that aren't already on disk are written to a new
+// temporary directory, which is returned as cleanup.
+func (s *source) materialize() (dir string, cleanup string, err error) {
+	if s.dir != "" {
+		return s.dir, "", nil
+	}
+	dir, err = ioutil.TempDir("", "cueinstance-")
+	if err != nil {
+		return "", "", err
+	}
+	for name, contents := range s.files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			os.RemoveAll(dir)
+			return "", "", fmt.Errorf("invalid source file name %q", name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", "", err
+		}
+	}
+	return dir, dir, nil
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package operator
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+)
+
+const (
+	conditionReady = "Ready"
+
+	// maxErrors is the number of errors written to status.lastErrors
+	maxErrors = 10
+
+	// statusInterval is the minimum time between status updates
+	statusInterval = 2 * time.Second
+)
+
+// statusWriter collects the state of a running instance and periodically writes it to the CueInstance's status
+type statusWriter struct {
+	client dynamic.Interface
+	cr     *unstructured.Unstructured
+	hash   string
+
+	state     controller.ClusterState
+	converged bool
+	dirty     bool
+
+	// errors are the last errors of the labels that haven't synced since, keyed by label
+	errors map[string]string
+}
+
+func newStatusWriter(client dynamic.Interface, cr *unstructured.Unstructured, hash string) *statusWriter {
+	return &statusWriter{
+		client: client,
+		cr:     cr,
+		hash:   hash,
+		dirty:  true,
+		errors: map[string]string{},
+	}
+}
+
+func (w *statusWriter) run(ctx context.Context, events <-chan controller.Event) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-events:
+			switch e := e.(type) {
+			case controller.StateEvent:
+				w.state = e.State
+				w.converged = e.Converged
+				w.dirty = true
+			case controller.ErrorEvent:
+				w.setError(e.Label, e.Err.Error())
+			case controller.SyncedEvent:
+				w.clearError(e.Label)
+			}
+		case <-ticker.C:
+			w.flush(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *statusWriter) setError(label, err string) {
+	if w.errors[label] == err {
+		return
+	}
+	w.errors[label] = err
+	w.dirty = true
+}
+
+func (w *statusWriter) clearError(label string) {
+	if _, ok := w.errors[label]; !ok {
+		return
+	}
+	delete(w.errors, label)
+	w.dirty = true
+}
+
+// lastErrors returns the errors ordered by label, at most maxErrors of them
+func (w *statusWriter) lastErrors() []interface{} {
+	labels := make([]string, 0, len(w.errors))
+	for label := range w.errors {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	if len(labels) > maxErrors {
+		labels = labels[:maxErrors]
+	}
+	errors := make([]interface{}, 0, len(labels))
+	for _, label := range labels {
+		errors = append(errors, w.errors[label])
+	}
+	return errors
+}
+
+func (w *statusWriter) flush(ctx context.Context) {
+	if !w.dirty {
+		return
+	}
+	err := updateStatus(ctx, w.client, w.cr, func(status map[string]interface{}) {
+		status["observedGeneration"] = w.cr.GetGeneration()
+		status["sourceHash"] = w.hash
+		status["inventory"] = inventory(w.state)
+		status["lastErrors"] = w.lastErrors()
+		if w.converged {
+			setCondition(status, conditionReady, "True", "Applied", "all fields have been applied")
+		} else {
+			setCondition(status, conditionReady, "False", "Progressing", "waiting for fields to become concrete")
+		}
+	})
+	if err != nil {
+		klog.V(1).Error(err, "could not update status")
+		return
+	}
+	w.dirty = false
+}
+
+// inventory lists the objects in the cluster state, ordered by path
+func inventory(state controller.ClusterState) []interface{} {
+	entries := make([]interface{}, 0, len(state))
+	for l, u := range state {
+		entry := map[string]interface{}{
+			"path":       strings.Join(l.Path, "."),
+			"apiVersion": l.GroupVersion().String(),
+			"resource":   l.Resource,
+		}
+		if !l.List {
+			entry["apiVersion"] = u.GetAPIVersion()
+			entry["kind"] = u.GetKind()
+			entry["name"] = u.GetName()
+		}
+		if l.Namespace != "" {
+			entry["namespace"] = l.Namespace
+		}
+		if l.ReadOnly {
+			entry["readOnly"] = true
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].(map[string]interface{})["path"].(string) < entries[j].(map[string]interface{})["path"].(string)
+	})
+	return entries
+}
+
+// updateStatus applies mutate to the latest status of the CueInstance and writes it, retrying on conflicts
+func updateStatus(ctx context.Context, client dynamic.Interface, cr *unstructured.Unstructured, mutate func(status map[string]interface{})) error {
+	resource := client.Resource(CueInstanceGVR).Namespace(cr.GetNamespace())
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := resource.Get(ctx, cr.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.GetUID() != cr.GetUID() {
+			// the CueInstance was recreated
+			return nil
+		}
+		status, _, err := unstructured.NestedMap(latest.Object, "status")
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			status = map[string]interface{}{}
+		}
+		mutate(status)
+		if err := unstructured.SetNestedMap(latest.Object, status, "status"); err != nil {
+			return err
+		}
+		_, err = resource.UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// setCondition sets a condition in status, keeping its transition time if the status is unchanged
+func setCondition(status map[string]interface{}, conditionType, conditionStatus, reason, message string) {
+	conditions, _ := status["conditions"].([]interface{})
+	now := metav1.Now().UTC().Format(time.RFC3339)
+	updated := make([]interface{}, 0, len(conditions)+1)
+	found := false
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			updated = append(updated, c)
+			continue
+		}
+		found = true
+		if condition["status"] != conditionStatus {
+			condition["lastTransitionTime"] = now
+		}
+		condition["status"] = conditionStatus
+		condition["reason"] = reason
+		condition["message"] = message
+		updated = append(updated, condition)
+	}
+	if !found {
+		updated = append(updated, map[string]interface{}{
+			"type":               conditionType,
+			"status":             conditionStatus,
+			"reason":             reason,
+			"message":            message,
+			"lastTransitionTime": now,
+		})
+	}
+	status["conditions"] = updated
+}
@@ -0,0 +1,214 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package policy enforces constraints, written in cue, on the objects of an instance before they are applied.
+//
+// A policy is a cue package with a deny and a warn struct, each keyed by "<apiVersion>/<kind>". Every object of that
+// apiVersion and kind is unified with the constraint; an object that conflicts with a deny constraint isn't applied,
+// and one that conflicts with a warn constraint is applied with a warning:
+//
+//	deny: "apps/v1/Deployment": spec: template: spec: containers: [...{resources: limits: {cpu: _, memory: _}}]
+//	warn: "v1/Pod": spec: containers: [...{image: =~"^registry.example.com/"}]
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/token"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/cuelock"
+)
+
+// Level is how a violation of a constraint is handled
+type Level string
+
+const (
+	// Deny constraints stop objects that violate them from being applied
+	Deny Level = "deny"
+
+	// Warn constraints report objects that violate them, which are still applied
+	Warn Level = "warn"
+)
+
+// levels are the top-level fields of a policy, in the order they are checked
+var levels = []Level{Deny, Warn}
+
+// Policy is a set of constraints, keyed by apiVersion/kind
+type Policy struct {
+	dir         string
+	constraints map[string][]constraint
+}
+
+type constraint struct {
+	level Level
+	value cue.Value
+}
+
+// Violation is a conflict between an object and a constraint
+type Violation struct {
+	Level Level
+
+	// Key is the apiVersion/kind of the constraint
+	Key string
+
+	// Path is the path of the conflicting field in the object, joined with "."
+	Path string
+
+	Message string
+
+	// Positions are the file:line:column positions of the constraint in the policy
+	Positions []string
+}
+
+func (v Violation) String() string {
+	s := fmt.Sprintf("%s: %s", v.Level, v.Message)
+	if v.Path != "" {
+		s = fmt.Sprintf("%s: %s: %s", v.Level, v.Path, v.Message)
+	}
+	if len(v.Positions) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(v.Positions, ", "))
+	}
+	return s
+}
+
+// ViolationError is returned for an object that violates deny constraints
+type ViolationError struct {
+	Object     string
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return fmt.Sprintf("%s violates policy: %s", e.Object, strings.Join(messages, "; "))
+}
+
+// Load reads the policy package in dir
+func Load(dir string) (*Policy, error) {
+	is := load.Instances([]string{"."}, &load.Config{Dir: dir})
+	if len(is) != 1 {
+		return nil, fmt.Errorf("expected one policy package in %s, found %d", dir, len(is))
+	}
+	cuelock.Lock()
+	defer cuelock.Unlock()
+	r := &cue.Runtime{}
+	instance, err := r.Build(is[0])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{dir: dir, constraints: map[string][]constraint{}}
+	for _, level := range levels {
+		v := instance.Lookup(string(level))
+		if !v.Exists() {
+			continue
+		}
+		fields, err := v.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a struct keyed by apiVersion/kind: %v", level, err)
+		}
+		for fields.Next() {
+			key := fields.Label()
+			if strings.Count(key, "/") < 1 {
+				return nil, fmt.Errorf("%s: %q must be apiVersion/kind, i.e. \"apps/v1/Deployment\"", level, key)
+			}
+			p.constraints[key] = append(p.constraints[key], constraint{level: level, value: fields.Value()})
+		}
+	}
+	return p, nil
+}
+
+// Check unifies obj with the constraints for its apiVersion and kind, and returns the violations. A nil policy has
+// no constraints.
+func (p *Policy) Check(obj *unstructured.Unstructured) []Violation {
+	if p == nil {
+		return nil
+	}
+	key := obj.GetAPIVersion() + "/" + obj.GetKind()
+	constraints := p.constraints[key]
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	// cue values aren't safe for concurrent use, and filling shares cue's index with every instance in the process
+	cuelock.Lock()
+	defer cuelock.Unlock()
+	var violations []Violation
+	for _, c := range constraints {
+		err := c.value.Fill(obj.Object).Validate(cue.Concrete(true))
+		for _, e := range errors.Errors(err) {
+			format, args := e.Msg()
+			message := fmt.Sprintf(format, args...)
+			if strings.HasPrefix(message, "incomplete value") {
+				// the constraint requires a field that the object doesn't set
+				message = fmt.Sprintf("required by policy (%s)", message)
+			}
+			path := e.Path()
+			if len(path) >= 2 {
+				// leave out the level and key of the constraint
+				path = path[2:]
+			}
+			positions := p.positions(e.Position(), e.InputPositions()...)
+			if len(positions) == 0 {
+				positions = p.positions(c.value.Pos())
+			}
+			violations = append(violations, Violation{
+				Level:     c.level,
+				Key:       key,
+				Path:      strings.Join(path, "."),
+				Message:   message,
+				Positions: positions,
+			})
+		}
+	}
+	return violations
+}
+
+// Denied returns a ViolationError for obj if any of the violations deny it
+func Denied(obj *unstructured.Unstructured, violations []Violation) error {
+	var denied []Violation
+	for _, v := range violations {
+		if v.Level == Deny {
+			denied = append(denied, v)
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+	name := obj.GetName()
+	if name == "" {
+		name = obj.GetGenerateName() + "*"
+	}
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return &ViolationError{Object: fmt.Sprintf("%s %s", obj.GetKind(), name), Violations: denied}
+}
+
+// positions formats positions in the policy as file:line:column, with files relative to the policy directory
+func (p *Policy) positions(pos token.Pos, more ...token.Pos) []string {
+	seen := map[string]struct{}{}
+	var positions []string
+	for _, pos := range append([]token.Pos{pos}, more...) {
+		if !pos.IsValid() || pos.Filename() == "" {
+			continue
+		}
+		s := fmt.Sprintf("%s:%d:%d", filepath.Join(p.dir, filepath.Base(pos.Filename())), pos.Line(), pos.Column())
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		positions = append(positions, s)
+	}
+	sort.Strings(positions)
+	return positions
+}
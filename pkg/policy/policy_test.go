@@ -0,0 +1,137 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package policy_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/policy"
+)
+
+const src = `package policy
+
+deny: "v1/ConfigMap": metadata: labels: env: "prod"
+warn: "v1/ConfigMap": data: team: "x"
+`
+
+func load(t *testing.T) *policy.Policy {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "policy.cue"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := policy.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func configMap(env, team string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      "config",
+			"namespace": "default",
+			"labels":    map[string]interface{}{"env": env},
+		},
+		"data": map[string]interface{}{"team": team},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	p := load(t)
+	tests := []struct {
+		name   string
+		obj    *unstructured.Unstructured
+		levels []policy.Level
+		denied bool
+	}{
+		{name: "compliant", obj: configMap("prod", "x")},
+		{name: "warned", obj: configMap("prod", "y"), levels: []policy.Level{policy.Warn}},
+		{name: "denied", obj: configMap("dev", "x"), levels: []policy.Level{policy.Deny}, denied: true},
+		{name: "denied and warned", obj: configMap("dev", "y"), levels: []policy.Level{policy.Deny, policy.Warn}, denied: true},
+		{name: "no constraints for kind", obj: &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "secret"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := p.Check(tt.obj)
+			var levels []policy.Level
+			for _, v := range violations {
+				levels = append(levels, v.Level)
+				if len(v.Positions) == 0 || !strings.Contains(v.Positions[0], "policy.cue:") {
+					t.Errorf("violation %s has no position in the policy", v)
+				}
+			}
+			if fmt.Sprint(levels) != fmt.Sprint(tt.levels) {
+				t.Errorf("got violations %v, want levels %v", violations, tt.levels)
+			}
+			if err := policy.Denied(tt.obj, violations); (err != nil) != tt.denied {
+				t.Errorf("got denied error %v, want denied %v", err, tt.denied)
+			}
+		})
+	}
+}
+
+func TestNilPolicy(t *testing.T) {
+	var p *policy.Policy
+	if violations := p.Check(configMap("dev", "y")); len(violations) != 0 {
+		t.Errorf("got violations %v from a nil policy", violations)
+	}
+}
+
+// policies are checked by several workers while the instance is unified, which share cue's global state, so this is
+// mostly a test for -race
+func TestCheckConcurrently(t *testing.T) {
+	h, err := harness.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Timeout = 20 * time.Second
+	h.Options.Workers = 4
+	h.Options.Policy = load(t)
+
+	r := &cue.Runtime{}
+	instance, err := r.Compile("test.cue", `
+import "list"
+
+for i in list.Range(0, 20, 1) {
+	"config-\(i)": {
+		apiVersion: "v1"
+		kind:       "ConfigMap"
+		metadata: {name: "config-\(i)", namespace: "default", labels: env: "prod"}
+		data: {team: [ if i mod 2 == 0 {"x"}, "y"][0], index: "\(i)"}
+	}
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.ApplyInstance(r, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.AssertApplied(t)
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("config-%d", i)
+		warned := result.Outcomes[path].Warning != ""
+		if want := i%2 == 1; warned != want {
+			t.Errorf("%s: got warning %q, want a warning %v", path, result.Outcomes[path].Warning, want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package preflight checks that the user applying an instance has the permissions it needs, before anything is
+// written, so that a missing permission doesn't leave an instance half applied.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/unifier"
+)
+
+var (
+	// managedVerbs are the verbs needed to apply an object and watch it for changes
+	managedVerbs = []string{"create", "patch", "get", "list", "watch"}
+
+	// referenceVerbs are the verbs needed to read and watch referenced or listed objects
+	referenceVerbs = []string{"get", "list", "watch"}
+
+	selfSubjectAccessReviews = schema.GroupVersionResource{Group: "authorization.k8s.io", Version: "v1", Resource: "selfsubjectaccessreviews"}
+	serviceAccounts          = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+)
+
+// Permission is a permission needed by a field of the instance
+type Permission struct {
+	// Path of the field, joined with "/"
+	Path string
+
+	// Context is the kube context of the cluster, or "" for the default cluster
+	Context string
+
+	// User is the user the request is made as, or "" for the invoking user
+	User string
+
+	Verb      string
+	Resource  schema.GroupVersionResource
+	Namespace string
+	Name      string
+}
+
+// Skipped is a field whose permissions can't be determined before applying, i.e. because its kind or namespace
+// depends on the cluster state
+type Skipped struct {
+	Path string
+	Err  error
+}
+
+// Denial is a permission that was denied
+type Denial struct {
+	Permission
+	Reason string
+}
+
+// Cluster is a cluster to check permissions in
+type Cluster struct {
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+
+	// Impersonate returns a client that impersonates a user, to check the permissions of service accounts. If nil,
+	// only the permission to impersonate them is checked.
+	Impersonate ensure.Impersonator
+}
+
+// Permissions returns the permissions needed by every field of instance, unified with an empty cluster state.
+// Clusters are keyed by kube context, with "" for the default cluster.
+func Permissions(runtime *cue.Runtime, instance *cue.Instance, clusters map[string]Cluster) ([]Permission, []Skipped, error) {
+	u := unifier.NewClusterUnifier(runtime, instance, nil)
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	total, err := u.Fill(nil, queue)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var permissions []Permission
+	var skipped []Skipped
+	for i := 0; i < total; i++ {
+		item, _ := queue.Get()
+		queue.Done(item)
+		label := item.(string)
+		p, err := fieldPermissions(u, clusters, label)
+		if err != nil {
+			skipped = append(skipped, Skipped{Path: label, Err: err})
+			continue
+		}
+		permissions = append(permissions, p...)
+	}
+	return permissions, skipped, nil
+}
+
+// fieldPermissions returns the permissions needed by the field at label
+func fieldPermissions(u *unifier.ClusterUnifier, clusters map[string]Cluster, label string) ([]Permission, error) {
+	attrs := u.Attributes(label)
+	context, _ := attrs.Get(attributes.Context)
+	cluster, ok := clusters[context]
+	if !ok {
+		return nil, fmt.Errorf("unknown kube context %q", context)
+	}
+	ref, err := u.Reference(nil, label)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := cluster.Mapper.RESTMapping(ref.GroupVersionKind.GroupKind(), ref.GroupVersionKind.Version)
+	if err != nil {
+		return nil, err
+	}
+	base := Permission{Path: label, Context: context, Resource: mapping.Resource, Namespace: ref.Namespace}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		base.Namespace = ""
+	}
+
+	var permissions []Permission
+	add := func(user string, verbs []string, name string) {
+		for _, verb := range verbs {
+			p := base
+			p.User, p.Verb = user, verb
+			// the name narrows the checks of single objects; lists and watches need every object
+			if verb == "get" || verb == "patch" {
+				p.Name = name
+			}
+			permissions = append(permissions, p)
+		}
+	}
+	if attrs.Flag(attributes.Ref) || attrs.Flag(attributes.List) {
+		add("", referenceVerbs, ref.Name)
+		return permissions, nil
+	}
+
+	sa, ok := attrs.Get(attributes.ServiceAccount)
+	if !ok {
+		add("", managedVerbs, ref.Name)
+		return permissions, nil
+	}
+	namespace, name := base.Namespace, sa
+	if i := strings.Index(sa, "/"); i >= 0 {
+		namespace, name = sa[:i], sa[i+1:]
+	}
+	if namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid service account %q", sa)
+	}
+	user := ensure.ServiceAccountUser(namespace, name)
+	permissions = append(permissions, Permission{Path: label, Context: context, Verb: "impersonate", Resource: serviceAccounts, Namespace: namespace, Name: name})
+	// objects are written as the service account, and read by the invoking user's informers
+	add(user, managedVerbs[:3], ref.Name)
+	add("", referenceVerbs[1:], ref.Name)
+	return permissions, nil
+}
+
+// Check issues a SelfSubjectAccessReview for each permission, and returns the permissions that were denied. The
+// permissions of other users are reviewed by impersonating them.
+func Check(ctx context.Context, clusters map[string]Cluster, permissions []Permission) ([]Denial, error) {
+	type key struct {
+		context, user, verb, namespace, name string
+		resource                             schema.GroupVersionResource
+	}
+	reviewed := map[key]string{}
+	var denials []Denial
+	for _, p := range permissions {
+		k := key{p.Context, p.User, p.Verb, p.Namespace, p.Name, p.Resource}
+		reason, ok := reviewed[k]
+		if !ok {
+			var allowed bool
+			var err error
+			if allowed, reason, err = review(ctx, clusters[p.Context], p); err != nil {
+				return nil, err
+			}
+			if allowed {
+				reason = ""
+			} else if reason == "" {
+				reason = "denied"
+			}
+			reviewed[k] = reason
+		}
+		if reason != "" {
+			denials = append(denials, Denial{Permission: p, Reason: reason})
+		}
+	}
+	return denials, nil
+}
+
+// review asks the cluster whether the user of p has p
+func review(ctx context.Context, cluster Cluster, p Permission) (allowed bool, reason string, err error) {
+	client := cluster.Client
+	if p.User != "" {
+		if cluster.Impersonate == nil {
+			return true, "", nil
+		}
+		if client, err = cluster.Impersonate(p.User); err != nil {
+			return false, "", err
+		}
+	}
+	attrs := map[string]interface{}{
+		"verb":     p.Verb,
+		"group":    p.Resource.Group,
+		"version":  p.Resource.Version,
+		"resource": p.Resource.Resource,
+	}
+	if p.Namespace != "" {
+		attrs["namespace"] = p.Namespace
+	}
+	if p.Name != "" {
+		attrs["name"] = p.Name
+	}
+	ssar := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec":       map[string]interface{}{"resourceAttributes": attrs},
+	}}
+	out, err := client.Resource(selfSubjectAccessReviews).Create(ctx, ssar, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("could not review %s %s: %v", p.Verb, p.Resource.GroupResource(), err)
+	}
+	allowed, _, _ = unstructured.NestedBool(out.Object, "status", "allowed")
+	reason, _, _ = unstructured.NestedString(out.Object, "status", "reason")
+	return allowed, reason, nil
+}
+
+// PrintDenials writes a table of denials, sorted by field
+func PrintDenials(out io.Writer, denials []Denial) error {
+	sorted := make([]Denial, len(denials))
+	copy(sorted, denials)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tCONTEXT\tUSER\tVERB\tRESOURCE\tNAMESPACE\tNAME\tREASON")
+	for _, d := range sorted {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Path, orDefault(d.Context, "(current)"), orDefault(d.User, "(you)"),
+			d.Verb, d.Resource.GroupResource(), orDefault(d.Namespace, "-"), orDefault(d.Name, "*"), d.Reason)
+	}
+	return w.Flush()
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package preflight_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cuebernetes/cuebectl/pkg/harness"
+	"github.com/cuebernetes/cuebectl/pkg/preflight"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// reviewer is a cluster that allows the verbs in allowed, for every user, and counts the reviews it is asked for
+type reviewer struct {
+	allowed map[string]bool
+	reviews int
+}
+
+func (r *reviewer) client() dynamic.Interface {
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		r.reviews++
+		ssar := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		verb, _, _ := unstructured.NestedString(ssar.Object, "spec", "resourceAttributes", "verb")
+		allowed := r.allowed[verb]
+		_ = unstructured.SetNestedField(ssar.Object, allowed, "status", "allowed")
+		if !allowed {
+			_ = unstructured.SetNestedField(ssar.Object, "no "+verb, "status", "reason")
+		}
+		return true, ssar, nil
+	})
+	return client
+}
+
+// format writes each permission on one line, sorted
+func format(permissions []preflight.Permission) string {
+	lines := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		lines = append(lines, fmt.Sprintf("%s %s %s %s %s %s/%s", p.Path, p.Context, p.User, p.Verb, p.Resource.Resource, p.Namespace, p.Name))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestPermissions(t *testing.T) {
+	mapper := harness.NewRESTMapper()
+	clusters := map[string]preflight.Cluster{"": {Mapper: mapper}, "spoke": {Mapper: mapper}}
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		skipped []string
+	}{
+		{
+			name: "managed",
+			src:  `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}}`,
+			want: []string{
+				"config   create configmaps default/",
+				"config   get configmaps default/config",
+				"config   list configmaps default/",
+				"config   patch configmaps default/config",
+				"config   watch configmaps default/",
+			},
+		},
+		{
+			name: "cluster-scoped",
+			src:  `ns: {apiVersion: "v1", kind: "Namespace", metadata: {name: "test", namespace: "default"}}`,
+			want: []string{
+				"ns   create namespaces /",
+				"ns   get namespaces /test",
+				"ns   list namespaces /",
+				"ns   patch namespaces /test",
+				"ns   watch namespaces /",
+			},
+		},
+		{
+			name: "reference",
+			src:  `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(ref)`,
+			want: []string{
+				"config   get configmaps default/config",
+				"config   list configmaps default/",
+				"config   watch configmaps default/",
+			},
+		},
+		{
+			name: "context",
+			src:  `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(ref, context="spoke")`,
+			want: []string{
+				"config spoke  get configmaps default/config",
+				"config spoke  list configmaps default/",
+				"config spoke  watch configmaps default/",
+			},
+		},
+		{
+			name: "service account in the object's namespace",
+			src:  `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(serviceAccount="deployer")`,
+			want: []string{
+				"config   impersonate serviceaccounts default/deployer",
+				"config   list configmaps default/",
+				"config   watch configmaps default/",
+				"config  system:serviceaccount:default:deployer create configmaps default/",
+				"config  system:serviceaccount:default:deployer get configmaps default/config",
+				"config  system:serviceaccount:default:deployer patch configmaps default/config",
+			},
+		},
+		{
+			name: "service account in another namespace",
+			src:  `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(serviceAccount="tenant/deployer")`,
+			want: []string{
+				"config   impersonate serviceaccounts tenant/deployer",
+				"config   list configmaps default/",
+				"config   watch configmaps default/",
+				"config  system:serviceaccount:tenant:deployer create configmaps default/",
+				"config  system:serviceaccount:tenant:deployer get configmaps default/config",
+				"config  system:serviceaccount:tenant:deployer patch configmaps default/config",
+			},
+		},
+		{
+			name:    "unknown context",
+			src:     `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(context="hub")`,
+			skipped: []string{"config"},
+		},
+		{
+			name:    "unknown kind",
+			src:     `widget: {apiVersion: "example.com/v1", kind: "Widget", metadata: name: "widget"}`,
+			skipped: []string{"widget"},
+		},
+		{
+			name:    "invalid service account",
+			src:     `config: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "config", namespace: "default"}} @cuebectl(serviceAccount="tenant/")`,
+			skipped: []string{"config"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &cue.Runtime{}
+			instance, err := r.Compile("test.cue", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			permissions, skipped, err := preflight.Permissions(r, instance, clusters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := format(permissions), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("got permissions:\n%s\nwant:\n%s", got, want)
+			}
+			var paths []string
+			for _, s := range skipped {
+				paths = append(paths, s.Path)
+			}
+			if fmt.Sprint(paths) != fmt.Sprint(tt.skipped) {
+				t.Errorf("got skipped %v, want %v", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	permission := func(path, user, verb string) preflight.Permission {
+		return preflight.Permission{Path: path, User: user, Verb: verb, Resource: configMaps, Namespace: "default"}
+	}
+	tests := []struct {
+		name        string
+		allowed     []string
+		impersonate bool
+		permissions []preflight.Permission
+		denied      []string
+		reviews     int
+	}{
+		{
+			name:        "allowed",
+			allowed:     []string{"get", "list"},
+			permissions: []preflight.Permission{permission("a", "", "get"), permission("a", "", "list")},
+			reviews:     2,
+		},
+		{
+			name:        "denied",
+			allowed:     []string{"get"},
+			permissions: []preflight.Permission{permission("a", "", "get"), permission("a", "", "create")},
+			denied:      []string{"a create: no create"},
+			reviews:     2,
+		},
+		{
+			name:        "the same permission is reviewed once",
+			permissions: []preflight.Permission{permission("a", "", "create"), permission("b", "", "create")},
+			denied:      []string{"a create: no create", "b create: no create"},
+			reviews:     1,
+		},
+		{
+			name:        "other users without impersonation",
+			permissions: []preflight.Permission{permission("a", "system:serviceaccount:default:deployer", "create")},
+		},
+		{
+			name:        "other users impersonated",
+			impersonate: true,
+			permissions: []preflight.Permission{permission("a", "system:serviceaccount:default:deployer", "create")},
+			denied:      []string{"a create: no create"},
+			reviews:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &reviewer{allowed: map[string]bool{}}
+			for _, verb := range tt.allowed {
+				r.allowed[verb] = true
+			}
+			cluster := preflight.Cluster{Client: r.client()}
+			if tt.impersonate {
+				cluster.Impersonate = func(string) (dynamic.Interface, error) { return cluster.Client, nil }
+			}
+			denials, err := preflight.Check(context.Background(), map[string]preflight.Cluster{"": cluster}, tt.permissions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var denied []string
+			for _, d := range denials {
+				denied = append(denied, fmt.Sprintf("%s %s: %s", d.Path, d.Verb, d.Reason))
+			}
+			if fmt.Sprint(denied) != fmt.Sprint(tt.denied) {
+				t.Errorf("got denials %v, want %v", denied, tt.denied)
+			}
+			if r.reviews != tt.reviews {
+				t.Errorf("got %d reviews, want %d", r.reviews, tt.reviews)
+			}
+		})
+	}
+}
+
+func TestPrintDenials(t *testing.T) {
+	denials := []preflight.Denial{
+		{Permission: preflight.Permission{Path: "b", Verb: "list", Resource: configMaps}, Reason: "denied"},
+		{Permission: preflight.Permission{Path: "a", Context: "spoke", User: "system:serviceaccount:default:deployer", Verb: "get", Resource: configMaps, Namespace: "default", Name: "config"}, Reason: "no get"},
+	}
+	var out bytes.Buffer
+	if err := preflight.PrintDenials(&out, denials); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header and 2 denials:\n%s", len(lines), out.String())
+	}
+	tests := []struct {
+		line   string
+		fields []string
+	}{
+		{line: lines[0], fields: []string{"FIELD", "CONTEXT", "USER", "VERB", "RESOURCE", "NAMESPACE", "NAME", "REASON"}},
+		{line: lines[1], fields: []string{"a", "spoke", "system:serviceaccount:default:deployer", "get", "configmaps", "default", "config", "no", "get"}},
+		{line: lines[2], fields: []string{"b", "(current)", "(you)", "list", "configmaps", "-", "*", "denied"}},
+	}
+	for _, tt := range tests {
+		if got := strings.Fields(tt.line); fmt.Sprint(got) != fmt.Sprint(tt.fields) {
+			t.Errorf("got row %v, want %v", got, tt.fields)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// DynamicClient wraps a dynamic.Interface so that requests for each GroupVersionResource are rate limited
+// separately, in addition to any limit on the client as a whole.
+type DynamicClient struct {
+	client dynamic.Interface
+	qps    float32
+	burst  int
+
+	limiters map[schema.GroupVersionResource]flowcontrol.RateLimiter
+	sync.Mutex
+}
+
+var _ dynamic.Interface = &DynamicClient{}
+
+// NewDynamicClient returns a client that allows qps requests per second, with bursts of up to burst requests, for
+// each GroupVersionResource.
+func NewDynamicClient(client dynamic.Interface, qps float32, burst int) *DynamicClient {
+	return &DynamicClient{
+		client:   client,
+		qps:      qps,
+		burst:    burst,
+		limiters: map[schema.GroupVersionResource]flowcontrol.RateLimiter{},
+	}
+}
+
+func (c *DynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	client := c.client.Resource(gvr)
+	return &namespaceableResource{
+		resource: resource{ResourceInterface: client, limiter: c.limiter(gvr)},
+		client:   client,
+	}
+}
+
+func (c *DynamicClient) limiter(gvr schema.GroupVersionResource) flowcontrol.RateLimiter {
+	c.Lock()
+	defer c.Unlock()
+	l, ok := c.limiters[gvr]
+	if !ok {
+		l = flowcontrol.NewTokenBucketRateLimiter(c.qps, c.burst)
+		c.limiters[gvr] = l
+	}
+	return l
+}
+
+type namespaceableResource struct {
+	resource
+	client dynamic.NamespaceableResourceInterface
+}
+
+func (r *namespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &resource{ResourceInterface: r.client.Namespace(namespace), limiter: r.limiter}
+}
+
+// resource waits for the limiter before each request
+type resource struct {
+	dynamic.ResourceInterface
+	limiter flowcontrol.RateLimiter
+}
+
+func (r *resource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Create(ctx, obj, options, subresources...)
+}
+
+func (r *resource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Update(ctx, obj, options, subresources...)
+}
+
+func (r *resource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.UpdateStatus(ctx, obj, options)
+}
+
+func (r *resource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ResourceInterface.Delete(ctx, name, options, subresources...)
+}
+
+func (r *resource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ResourceInterface.DeleteCollection(ctx, options, listOptions)
+}
+
+func (r *resource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Get(ctx, name, options, subresources...)
+}
+
+func (r *resource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.List(ctx, opts)
+}
+
+func (r *resource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Watch(ctx, opts)
+}
+
+func (r *resource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
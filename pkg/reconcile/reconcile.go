@@ -0,0 +1,348 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package reconcile is the API for embedding cuebectl: it applies a cue instance to a cluster and keeps it unified
+// with the cluster state, without going through the command line.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2/klogr"
+
+	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/controller"
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/events"
+	"github.com/cuebernetes/cuebectl/pkg/policy"
+	"github.com/cuebernetes/cuebectl/pkg/redact"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+	"github.com/cuebernetes/cuebectl/pkg/unifier"
+)
+
+// readyInterval is how often Run checks whether the controller has synced, until it has
+const readyInterval = 100 * time.Millisecond
+
+// Termination is the policy for when a reconcile stops
+type Termination int
+
+const (
+	// UntilConverged stops once every field of the instance has been applied
+	UntilConverged Termination = iota
+
+	// UntilConvergedOrError stops once every field has been applied, or at the first error. Fields that aren't
+	// concrete yet are waited for, since they can become concrete as other fields are applied.
+	UntilConvergedOrError
+
+	// Continuous keeps the instance unified with the cluster until the context is done
+	Continuous
+)
+
+// Options configure a Reconciler. The zero value applies an instance named "cuebectl" with the defaults of the
+// apply command.
+type Options struct {
+	// Name of the instance, used to label the objects it manages
+	Name string
+
+	// Adopt existing objects that aren't managed by any instance
+	Adopt bool
+
+	// ForceAdopt existing objects that are managed by another instance
+	ForceAdopt bool
+
+	// Prune deletes the objects of fields that are no longer in the instance
+	Prune bool
+
+	// Ensurer constructs the ensurer that writes objects. Defaults to ensure.DynamicFactory.
+	Ensurer ensure.Factory
+
+	// Backend is the name of a registered ensurer backend, used instead of Ensurer. Backends that don't write to the
+	// cluster are given an in-memory cluster, and don't record events.
+	Backend string
+
+	// Target configures where the backend writes to
+	Target ensure.Target
+
+	// Clusters are the clusters other than the default cluster, keyed by the kube context that fields name with
+	// @cuebectl(context=...)
+	Clusters map[string]controller.Cluster
+
+	// Impersonate returns a client for the default cluster that impersonates a user, for fields with
+	// @cuebectl(serviceAccount=...)
+	Impersonate ensure.Impersonator
+
+	// Policy constrains objects before they are applied. Objects that violate deny constraints fail, and warnings are
+	// kept in the outcome of the field.
+	Policy *policy.Policy
+
+	// ShowSecrets leaves Secret payloads, and fields marked with @cuebectl(sensitive), unmasked in errors, warnings,
+	// events and logs
+	ShowSecrets bool
+
+	// InformerFactory constructs the informers that watch the cluster. Defaults to
+	// cache.DefaultScopedDynamicInformerFactory.
+	InformerFactory cache.ScopedDynamicInformerFactory
+
+	// Recorder records events for changes to objects. If nil, no events are recorded.
+	Recorder *events.Recorder
+
+	// Logger logs the outcome of each field. Defaults to klog.
+	Logger logr.Logger
+
+	// Workers is the number of fields, and of changes to objects, processed concurrently. Defaults to 1.
+	Workers int
+
+	// Termination decides when Run returns. Defaults to UntilConverged.
+	Termination Termination
+
+	// Subscribers receive every event published while reconciling, i.e. to report progress
+	Subscribers []controller.Subscriber
+
+	// Ready is called once, after every field has been reconciled at least once and the informers watching the
+	// cluster have synced, i.e. to report readiness
+	Ready func()
+}
+
+// Status is the outcome for a field of the instance
+type Status string
+
+const (
+	// Applied fields have an object in the cluster that was created or updated from the field
+	Applied Status = "Applied"
+
+	// Referenced fields are read-only references to existing objects
+	Referenced Status = "Referenced"
+
+	// Listed fields are read-only lists of existing objects
+	Listed Status = "Listed"
+
+	// Failed fields haven't been applied, and the last attempt failed
+	Failed Status = "Failed"
+)
+
+// Outcome is the result of reconciling one field of the instance
+type Outcome struct {
+	// Path of the field, joined with "/"
+	Path   string
+	Status Status
+
+	// Object is the object in the cluster for the field, or a list with the listed objects as items. It is nil if
+	// the field failed.
+	Object *unstructured.Unstructured
+
+	// Err is the last error for the field. It may be set for applied fields that failed before succeeding.
+	Err error
+
+	// Warning is the last warning for the field, i.e. from a policy
+	Warning string
+}
+
+// Result is the result of a reconcile
+type Result struct {
+	// Converged is set if every field of the instance was applied
+	Converged bool
+
+	// Total is the number of fields in the instance
+	Total int
+
+	// Outcomes of the fields that were applied or failed, by path
+	Outcomes map[string]Outcome
+
+	// State is the last cluster state published by the controller. Its objects aren't redacted.
+	State controller.ClusterState
+
+	// Redactor masks the sensitive values of the instance, i.e. before objects are printed. It is nil if secrets are
+	// shown.
+	Redactor *redact.Redactor
+}
+
+// Reconciler applies a cue instance to a cluster
+type Reconciler struct {
+	controller *controller.CueInstanceController
+	options    Options
+}
+
+// NewReconciler returns a Reconciler for instance, which was built with runtime
+func NewReconciler(client dynamic.Interface, mapper meta.RESTMapper, runtime *cue.Runtime, instance *cue.Instance, options Options) (*Reconciler, error) {
+	if options.Name == "" {
+		options.Name = "cuebectl"
+	}
+	if options.Logger == nil {
+		options.Logger = klogr.New()
+	}
+	if options.Backend != "" {
+		var err error
+		if client, err = useBackend(client, &options); err != nil {
+			return nil, err
+		}
+	}
+	c, err := controller.NewCueInstanceController(client, mapper, runtime, instance, controller.Options{
+		Name:            ensure.InstanceName(options.Name),
+		Adopt:           options.Adopt,
+		ForceAdopt:      options.ForceAdopt,
+		Prune:           options.Prune,
+		Workers:         options.Workers,
+		Recorder:        options.Recorder,
+		Ensurer:         options.Ensurer,
+		InformerFactory: options.InformerFactory,
+		Clusters:        options.Clusters,
+		Impersonate:     options.Impersonate,
+		Policy:          options.Policy,
+		ShowSecrets:     options.ShowSecrets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciler{controller: c, options: options}, nil
+}
+
+// useBackend sets the ensurer of options to the one constructed by the backend, and returns the client for the
+// cluster the backend ensures objects in. The other clusters in options are replaced in the same way.
+func useBackend(client dynamic.Interface, options *Options) (dynamic.Interface, error) {
+	backend, err := ensure.Lookup(options.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if options.Ensurer, err = backend.New(options.Target); err != nil {
+		return nil, err
+	}
+	if backend.Cluster == ensure.LiveCluster {
+		return client, nil
+	}
+
+	// simulated clusters don't authorize requests, so impersonated users write to the same simulated cluster
+	options.Recorder = nil
+	simulated := func(client dynamic.Interface) (dynamic.Interface, ensure.Impersonator, error) {
+		source := client
+		if backend.Cluster == ensure.EmptyCluster {
+			source = nil
+		}
+		c, err := simulate.NewCluster(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, func(string) (dynamic.Interface, error) { return c, nil }, nil
+	}
+	clusters := make(map[string]controller.Cluster, len(options.Clusters))
+	for context, cluster := range options.Clusters {
+		if cluster.Client, cluster.Impersonate, err = simulated(cluster.Client); err != nil {
+			return nil, err
+		}
+		clusters[context] = cluster
+	}
+	options.Clusters = clusters
+	client, options.Impersonate, err = simulated(client)
+	return client, err
+}
+
+// Run reconciles the instance until ctx is done, a subscriber fails, or the termination policy is met. The result
+// covers every field that was applied or failed so far; it is returned along with any error.
+func (r *Reconciler) Run(ctx context.Context) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// the controller's workers may still be syncing a label, and must not outlive Run
+	defer r.controller.Wait()
+	defer cancel()
+
+	result := &Result{Outcomes: map[string]Outcome{}, Redactor: r.controller.Redactor()}
+	eventChan := make(chan controller.Event, controller.EventBufferSize)
+	total, err := r.controller.Start(ctx, eventChan)
+	result.Total = total
+	if err != nil {
+		return result, err
+	}
+	if r.options.Ready != nil {
+		go func() {
+			if err := wait.PollImmediateUntil(readyInterval, func() (bool, error) {
+				return r.controller.HasSynced(), nil
+			}, ctx.Done()); err == nil {
+				r.options.Ready()
+			}
+		}()
+	}
+
+	for {
+		select {
+		case e := <-eventChan:
+			for _, s := range r.options.Subscribers {
+				if err := s.Handle(e); err != nil {
+					return result, err
+				}
+			}
+			switch e := e.(type) {
+			case controller.StateEvent:
+				r.update(result, e)
+				if e.Converged && r.options.Termination != Continuous {
+					return result, nil
+				}
+			case controller.WarningEvent:
+				r.warn(result, e)
+			case controller.ErrorEvent:
+				r.fail(result, e)
+				if r.options.Termination == UntilConvergedOrError && !isNotConcrete(e.Err) {
+					return result, e.Err
+				}
+			}
+		case <-ctx.Done():
+			return result, nil
+		}
+	}
+}
+
+// isNotConcrete returns true for errors from fields that can't be applied yet because they aren't concrete
+func isNotConcrete(err error) bool {
+	var notConcrete *unifier.NotConcreteError
+	return errors.As(err, &notConcrete)
+}
+
+// update records the outcome of every field in the state
+func (r *Reconciler) update(result *Result, e controller.StateEvent) {
+	result.Converged = e.Converged
+	result.State = e.State
+	result.Total = r.controller.Total()
+	for l, u := range e.State {
+		path := strings.Join(l.Path, "/")
+		status := Applied
+		switch {
+		case l.List:
+			status = Listed
+		case l.ReadOnly:
+			status = Referenced
+		}
+		previous, ok := result.Outcomes[path]
+		if !ok || previous.Status != status {
+			r.options.Logger.V(1).Info("reconciled field", "path", path, "status", status, "name", u.GetName(), "namespace", u.GetNamespace())
+		}
+		result.Outcomes[path] = Outcome{Path: path, Status: status, Object: u, Err: previous.Err, Warning: previous.Warning}
+	}
+}
+
+// warn records a warning for a field, which is failed until it is applied
+func (r *Reconciler) warn(result *Result, e controller.WarningEvent) {
+	r.options.Logger.V(1).Info("warning for field", "path", e.Label, "warning", e.Message)
+	outcome, ok := result.Outcomes[e.Label]
+	if !ok {
+		outcome = Outcome{Path: e.Label, Status: Failed}
+	}
+	outcome.Warning = e.Message
+	result.Outcomes[e.Label] = outcome
+}
+
+// fail records an error for a field, which is failed unless it was applied before
+func (r *Reconciler) fail(result *Result, e controller.ErrorEvent) {
+	r.options.Logger.V(1).Info("could not reconcile field", "path", e.Label, "error", e.Err.Error())
+	outcome, ok := result.Outcomes[e.Label]
+	if !ok {
+		outcome = Outcome{Path: e.Label, Status: Failed}
+	}
+	outcome.Err = e.Err
+	result.Outcomes[e.Label] = outcome
+}
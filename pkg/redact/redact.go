@@ -0,0 +1,290 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package redact masks Secret payloads, and fields marked with @cuebectl(sensitive), in objects and in messages, so
+// that they don't end up in errors, events, diffs or logs.
+package redact
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
+	"github.com/cuebernetes/cuebectl/pkg/cuelock"
+)
+
+// Mask replaces sensitive values
+const Mask = "<redacted>"
+
+// MinLength is the length of the shortest value that is masked in messages. Shorter values are only masked in
+// objects, since masking them in messages would mask unrelated text.
+const MinLength = 4
+
+// maxDepth limits how deep the instance is searched for sensitive fields
+const maxDepth = 32
+
+// secretFields are the fields of a Secret with its payload
+var secretFields = []string{"data", "stringData"}
+
+// Redactor masks the sensitive values of an instance. Values are collected from the instance, and from the objects
+// observed while it is applied. A nil Redactor masks nothing.
+type Redactor struct {
+	// sensitive are the paths of sensitive fields within the object of each top-level field. An empty path marks the
+	// whole object, except apiVersion, kind and metadata.
+	sensitive map[string][][]string
+
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// New returns a Redactor for the instance v
+func New(v cue.Value) *Redactor {
+	r := &Redactor{sensitive: map[string][][]string{}, values: map[string]struct{}{}}
+	cuelock.RLock()
+	defer cuelock.RUnlock()
+	fields, err := v.Fields()
+	if err != nil {
+		return r
+	}
+	for fields.Next() {
+		label, field := fields.Label(), fields.Value()
+		r.findSensitive(label, field, nil, 0)
+		kind, _ := field.Lookup("kind").String()
+		if kind == "Secret" {
+			for _, name := range secretFields {
+				r.addCue(field.Lookup(name), name == "data")
+			}
+		}
+		for _, path := range r.sensitive[label] {
+			r.addCue(field.Lookup(path...), false)
+		}
+	}
+	r.rebuild()
+	return r
+}
+
+// findSensitive records the paths of the fields marked with @cuebectl(sensitive) in v
+func (r *Redactor) findSensitive(label string, v cue.Value, path []string, depth int) {
+	if attributes.Parse(v).Flag(attributes.Sensitive) {
+		r.sensitive[label] = append(r.sensitive[label], append([]string(nil), path...))
+		return
+	}
+	if depth >= maxDepth || v.IncompleteKind() != cue.StructKind {
+		return
+	}
+	fields, err := v.Fields()
+	if err != nil {
+		return
+	}
+	for fields.Next() {
+		r.findSensitive(label, fields.Value(), append(path, fields.Label()), depth+1)
+	}
+}
+
+// Observe collects the sensitive values of u, the object (or list of objects) for the top-level field label
+func (r *Redactor) Observe(label string, u *unstructured.Unstructured) {
+	if r == nil || u == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.values)
+	for _, obj := range objects(u) {
+		if isSecret(obj) {
+			for _, name := range secretFields {
+				r.addValue(obj[name], name == "data")
+			}
+		}
+		for _, path := range r.sensitive[label] {
+			if len(path) == 0 {
+				for k, v := range obj {
+					if k != "apiVersion" && k != "kind" && k != "metadata" {
+						r.addValue(v, false)
+					}
+				}
+				continue
+			}
+			if v, ok, _ := unstructured.NestedFieldNoCopy(obj, path...); ok {
+				r.addValue(v, false)
+			}
+		}
+	}
+	if len(r.values) != n {
+		r.rebuild()
+	}
+}
+
+// Object returns a copy of u, the object (or list of objects) for the top-level field label, with its sensitive
+// values masked
+func (r *Redactor) Object(label string, u *unstructured.Unstructured) *unstructured.Unstructured {
+	if r == nil || u == nil {
+		return u
+	}
+	out := u.DeepCopy()
+	for _, obj := range objects(out) {
+		if isSecret(obj) {
+			for _, name := range secretFields {
+				maskLeaves(obj, name)
+			}
+		}
+		for _, path := range r.sensitive[label] {
+			if len(path) == 0 {
+				for k := range obj {
+					if k != "apiVersion" && k != "kind" && k != "metadata" {
+						obj[k] = Mask
+					}
+				}
+				continue
+			}
+			if _, ok, _ := unstructured.NestedFieldNoCopy(obj, path...); ok {
+				_ = unstructured.SetNestedField(obj, Mask, path...)
+			}
+		}
+	}
+	return out
+}
+
+// String masks the sensitive values in s
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// Error returns err with the sensitive values in its message masked. The original error can still be unwrapped.
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	message := r.String(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string { return e.message }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// addCue collects the concrete values in v
+func (r *Redactor) addCue(v cue.Value, base64Encoded bool) {
+	if !v.Exists() {
+		return
+	}
+	if v.IncompleteKind() == cue.StructKind {
+		fields, err := v.Fields()
+		if err != nil {
+			return
+		}
+		for fields.Next() {
+			r.addCue(fields.Value(), base64Encoded)
+		}
+		return
+	}
+	var x interface{}
+	if err := v.Decode(&x); err == nil {
+		r.addValue(x, base64Encoded)
+	}
+}
+
+// addValue collects the scalar values in v. Base64 encoded values are also collected decoded.
+func (r *Redactor) addValue(v interface{}, base64Encoded bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			r.addValue(e, base64Encoded)
+		}
+	case []interface{}:
+		for _, e := range v {
+			r.addValue(e, base64Encoded)
+		}
+	case string:
+		r.add(v)
+		if base64Encoded {
+			if decoded, err := base64.StdEncoding.DecodeString(v); err == nil && utf8.Valid(decoded) {
+				r.add(string(decoded))
+			}
+		}
+	case bool, nil:
+	default:
+		r.add(fmt.Sprint(v))
+	}
+}
+
+func (r *Redactor) add(s string) {
+	if len(s) >= MinLength && s != Mask {
+		r.values[s] = struct{}{}
+	}
+}
+
+// rebuild updates the replacer with the collected values, longest first so that values containing other values are
+// masked whole
+func (r *Redactor) rebuild() {
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, Mask)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// objects returns the content of u, or of its items if it is a list
+func objects(u *unstructured.Unstructured) []map[string]interface{} {
+	items, ok := u.Object["items"].([]interface{})
+	if !ok {
+		return []map[string]interface{}{u.Object}
+	}
+	var objs []map[string]interface{}
+	for _, i := range items {
+		if obj, ok := i.(map[string]interface{}); ok {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+func isSecret(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+// maskLeaves masks the values of the map at obj[name]
+func maskLeaves(obj map[string]interface{}, name string) {
+	m, ok := obj[name].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k := range m {
+		m[k] = Mask
+	}
+}
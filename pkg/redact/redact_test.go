@@ -0,0 +1,209 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package redact_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/cuebernetes/cuebectl/pkg/redact"
+)
+
+const src = `
+token: {
+	apiVersion: "v1"
+	kind:       "Secret"
+	metadata: {name: "token", namespace: "default"}
+	data: token: "c2VjcmV0LXRva2Vu" // secret-token
+	stringData: pin: "123"
+}
+config: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "config", namespace: "default"}
+	data: {
+		public: "visible-value"
+		nested: {
+			password: "hunter22"
+			inner: key: "deep-value"
+		} @cuebectl(sensitive)
+	}
+}
+db: {
+	apiVersion: "example.com/v1"
+	kind:       "Database"
+	metadata: name: "db"
+	spec: {
+		users: ["alice-user", "bob-user"]
+		size: 12345
+	}
+} @cuebectl(sensitive)
+`
+
+func newRedactor(t *testing.T) *redact.Redactor {
+	t.Helper()
+	r := &cue.Runtime{}
+	instance, err := r.Compile("test.cue", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redact.New(instance.Value())
+}
+
+func TestString(t *testing.T) {
+	r := newRedactor(t)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "secret data", in: "token is c2VjcmV0LXRva2Vu", want: "token is <redacted>"},
+		{name: "decoded secret data", in: "token is secret-token", want: "token is <redacted>"},
+		{name: "shorter than MinLength", in: "pin is 123", want: "pin is 123"},
+		{name: "nested sensitive field", in: "password hunter22, key deep-value", want: "password <redacted>, key <redacted>"},
+		{name: "sensitive top-level field", in: "users alice-user and bob-user of size 12345", want: "users <redacted> and <redacted> of size <redacted>"},
+		{name: "metadata of sensitive field", in: "database db", want: "database db"},
+		{name: "not sensitive", in: "public visible-value", want: "public visible-value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.String(tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinLength(t *testing.T) {
+	r := newRedactor(t)
+	for _, value := range []string{"abc", "abcd"} {
+		r.Observe("token", &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"stringData": map[string]interface{}{"value": value},
+		}})
+	}
+	if got := r.String("abc, abcd"); got != "abc, "+redact.Mask {
+		t.Errorf("got %q, want only the value of at least %d characters masked", got, redact.MinLength)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	r := newRedactor(t)
+	// a list of objects for the field, with a nested value only the cluster has
+	r.Observe("config", &unstructured.Unstructured{Object: map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"data": map[string]interface{}{
+					"nested": map[string]interface{}{"list": []interface{}{"from-cluster"}},
+				},
+			},
+		},
+	}})
+	if got := r.String("observed from-cluster"); got != "observed "+redact.Mask {
+		t.Errorf("got %q, want the observed value masked", got)
+	}
+}
+
+func TestObject(t *testing.T) {
+	r := newRedactor(t)
+	tests := []struct {
+		label  string
+		obj    map[string]interface{}
+		masked [][]string
+		kept   [][]string
+	}{
+		{
+			label: "token",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "token"},
+				"data":       map[string]interface{}{"token": "c2VjcmV0LXRva2Vu"},
+				"stringData": map[string]interface{}{"pin": "123"},
+			},
+			masked: [][]string{{"data", "token"}, {"stringData", "pin"}},
+			kept:   [][]string{{"metadata", "name"}},
+		},
+		{
+			label: "config",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"data": map[string]interface{}{
+					"public": "visible-value",
+					"nested": map[string]interface{}{"password": "hunter22"},
+				},
+			},
+			masked: [][]string{{"data", "nested"}},
+			kept:   [][]string{{"data", "public"}},
+		},
+		{
+			label: "db",
+			obj: map[string]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "Database",
+				"metadata":   map[string]interface{}{"name": "db"},
+				"spec":       map[string]interface{}{"size": int64(1)},
+			},
+			masked: [][]string{{"spec"}},
+			kept:   [][]string{{"metadata", "name"}, {"kind"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			in := &unstructured.Unstructured{Object: tt.obj}
+			before := in.DeepCopy()
+			out := r.Object(tt.label, in)
+			for _, path := range tt.masked {
+				if v, _, _ := unstructured.NestedFieldNoCopy(out.Object, path...); v != redact.Mask {
+					t.Errorf("%s: got %v, want it masked", strings.Join(path, "."), v)
+				}
+			}
+			for _, path := range tt.kept {
+				got, _, _ := unstructured.NestedFieldNoCopy(out.Object, path...)
+				want, _, _ := unstructured.NestedFieldNoCopy(before.Object, path...)
+				if got != want {
+					t.Errorf("%s: got %v, want %v", strings.Join(path, "."), got, want)
+				}
+			}
+			if !reflect.DeepEqual(in.Object, before.Object) {
+				t.Errorf("the object passed in was modified: %v", in.Object)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	r := newRedactor(t)
+	cause := errors.New("could not create user alice-user")
+	err := r.Error(cause)
+	if err.Error() != "could not create user "+redact.Mask {
+		t.Errorf("got %q, want the value masked", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("the masked error doesn't unwrap to its cause")
+	}
+	if plain := errors.New("nothing sensitive"); r.Error(plain) != plain {
+		t.Error("got a new error for a message without sensitive values")
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *redact.Redactor
+	if got := r.String("alice-user"); got != "alice-user" {
+		t.Errorf("got %q from a nil redactor", got)
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Secret"}}
+	if r.Object("token", u) != u {
+		t.Error("a nil redactor copied the object")
+	}
+}
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package schemas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/format"
+	cuejson "cuelang.org/go/encoding/json"
+	"cuelang.org/go/encoding/jsonschema"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// GenDir is the location of generated packages, relative to a cue module root
+	GenDir = "cue.mod/gen"
+
+	// FileName is the name of the file written into each generated package
+	FileName = "cuebectl_gen.cue"
+
+	header = "// Code generated by cuebectl import schemas. DO NOT EDIT.\n\n"
+)
+
+// Generator collects openapi v3 schemas for kinds and renders them as cue definitions, one package per GroupVersion.
+// Every definition fixes apiVersion and kind, so that values using them are complete kube objects.
+type Generator struct {
+	schemas map[schema.GroupVersion]map[string]map[string]interface{}
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: map[schema.GroupVersion]map[string]map[string]interface{}{},
+	}
+}
+
+// Add registers the schema for a kind, replacing any previously registered schema.
+// A nil schema results in an open definition that only fixes apiVersion and kind.
+func (g *Generator) Add(gvk schema.GroupVersionKind, openAPIV3Schema map[string]interface{}) {
+	gv := gvk.GroupVersion()
+	if _, ok := g.schemas[gv]; !ok {
+		g.schemas[gv] = map[string]map[string]interface{}{}
+	}
+	g.schemas[gv][gvk.Kind] = withTypeMeta(gvk, openAPIV3Schema)
+}
+
+func (g *Generator) has(gvk schema.GroupVersionKind) bool {
+	kinds, ok := g.schemas[gvk.GroupVersion()]
+	if !ok {
+		return false
+	}
+	_, ok = kinds[gvk.Kind]
+	return ok
+}
+
+// AddCRD registers the schemas of every served version of a CustomResourceDefinition. Both v1 and v1beta1 CRDs
+// are supported.
+func (g *Generator) AddCRD(crd *unstructured.Unstructured) error {
+	group, _, err := unstructured.NestedString(crd.Object, "spec", "group")
+	if err != nil {
+		return err
+	}
+	kind, _, err := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	if err != nil {
+		return err
+	}
+	if group == "" || kind == "" {
+		return fmt.Errorf("%s is not a valid CustomResourceDefinition", crd.GetName())
+	}
+
+	// v1beta1 allowed a single schema shared across all versions
+	shared, _, err := unstructured.NestedMap(crd.Object, "spec", "validation", "openAPIV3Schema")
+	if err != nil {
+		return err
+	}
+
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		version, _, err := unstructured.NestedString(crd.Object, "spec", "version")
+		if err != nil {
+			return err
+		}
+		versions = []interface{}{map[string]interface{}{"name": version, "served": true}}
+	}
+
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if served, ok := version["served"].(bool); ok && !served {
+			continue
+		}
+		name, _ := version["name"].(string)
+		if name == "" {
+			continue
+		}
+		s, _, err := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			s = shared
+		}
+		g.Add(schema.GroupVersionKind{Group: group, Version: name, Kind: kind}, s)
+	}
+	return nil
+}
+
+// AddDiscovery registers an open definition for every kind served by the cluster that doesn't yet have a schema.
+func (g *Generator) AddDiscovery(client discovery.DiscoveryInterface) error {
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return err
+		}
+		klog.Warningf("discovery is incomplete, some schemas will be missing: %v", err)
+	}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.APIResources {
+			// skip subresources
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			gvk := gv.WithKind(r.Kind)
+			if g.has(gvk) {
+				continue
+			}
+			g.Add(gvk, nil)
+		}
+	}
+	return nil
+}
+
+// Files renders each collected GroupVersion as a cue file, keyed by its path relative to the module root.
+func (g *Generator) Files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for gv, kinds := range g.schemas {
+		b, err := render(gv, kinds)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", gv, err)
+		}
+		files[filepath.Join(GenDir, PackagePath(gv), FileName)] = b
+	}
+	return files, nil
+}
+
+// Write renders and writes all packages below root, returning the written paths in lexical order.
+func (g *Generator) Write(root string) ([]string, error) {
+	files, err := g.Files()
+	if err != nil {
+		return nil, err
+	}
+	written := make([]string, 0, len(files))
+	for p, b := range files {
+		path := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return nil, err
+		}
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	return written, nil
+}
+
+// PackagePath returns the import path of the generated package for a GroupVersion.
+// Groups without a dot (including the core group) can't be used as the first element of a cue import path, so they
+// are placed below k8s.io/api, alongside the packages generated from k8s.io/api by `cue get go`.
+func PackagePath(gv schema.GroupVersion) string {
+	group := gv.Group
+	if group == "" {
+		group = "core"
+	}
+	if !strings.Contains(group, ".") {
+		group = "k8s.io/api/" + group
+	}
+	return group + "/" + gv.Version
+}
+
+func render(gv schema.GroupVersion, kinds map[string]map[string]interface{}) ([]byte, error) {
+	b, err := json.Marshal(map[string]interface{}{"definitions": kinds})
+	if err != nil {
+		return nil, err
+	}
+	r := cue.Runtime{}
+	inst, err := cuejson.Decode(&r, gv.String()+".json", b)
+	if err != nil {
+		return nil, err
+	}
+	f, err := jsonschema.Extract(inst, &jsonschema.Config{PkgName: gv.Version})
+	if err != nil {
+		return nil, err
+	}
+
+	// the root of the schema is unconstrained, drop the top-level emit value
+	decls := make([]ast.Decl, 0, len(f.Decls))
+	for _, d := range f.Decls {
+		if e, ok := d.(*ast.EmbedDecl); ok {
+			if i, ok := e.Expr.(*ast.Ident); ok && i.Name == "_" {
+				continue
+			}
+		}
+		decls = append(decls, d)
+	}
+	f.Decls = decls
+
+	out, err := format.Node(f, format.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(header), out...), nil
+}
+
+// withTypeMeta returns a copy of s that requires apiVersion and kind to be fixed to the values for gvk
+func withTypeMeta(gvk schema.GroupVersionKind, s map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{"type": "object"}
+	if s != nil {
+		out = normalize(s).(map[string]interface{})
+	}
+	properties, ok := out["properties"].(map[string]interface{})
+	if !ok {
+		properties = map[string]interface{}{}
+	}
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	properties["apiVersion"] = map[string]interface{}{"type": "string", "enum": []interface{}{apiVersion}}
+	properties["kind"] = map[string]interface{}{"type": "string", "enum": []interface{}{kind}}
+	if _, ok := properties["metadata"]; !ok {
+		properties["metadata"] = map[string]interface{}{"type": "object"}
+	}
+	out["properties"] = properties
+
+	required := []interface{}{"apiVersion", "kind"}
+	if r, ok := out["required"].([]interface{}); ok {
+		for _, f := range r {
+			if f != "apiVersion" && f != "kind" {
+				required = append(required, f)
+			}
+		}
+	}
+	out["required"] = required
+	return out
+}
+
+// normalize deep copies a schema and rewrites kubernetes extensions that jsonschema doesn't understand
+func normalize(in interface{}) interface{} {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[k] = normalize(val)
+		}
+		if intOrString, ok := v["x-kubernetes-int-or-string"].(bool); ok && intOrString {
+			delete(out, "anyOf")
+			out["type"] = []interface{}{"integer", "string"}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = normalize(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
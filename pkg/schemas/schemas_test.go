@@ -0,0 +1,219 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package schemas_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/cuebernetes/cuebectl/pkg/schemas"
+)
+
+var discovery = &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{
+	{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			{Name: "pods", Kind: "Pod", Namespaced: true},
+			{Name: "pods/status", Kind: "Pod", Namespaced: true},
+		},
+	},
+	{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "widgets", Kind: "Widget", Namespaced: true},
+			{Name: "gadgets", Kind: "Gadget", Namespaced: true},
+		},
+	},
+}}}
+
+var widgetCRD = &unstructured.Unstructured{Object: map[string]interface{}{
+	"apiVersion": "apiextensions.k8s.io/v1",
+	"kind":       "CustomResourceDefinition",
+	"metadata":   map[string]interface{}{"name": "widgets.example.com"},
+	"spec": map[string]interface{}{
+		"group": "example.com",
+		"names": map[string]interface{}{"kind": "Widget", "plural": "widgets"},
+		"versions": []interface{}{
+			map[string]interface{}{
+				"name":   "v1",
+				"served": true,
+				"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"type":     "object",
+							"required": []interface{}{"size"},
+							"properties": map[string]interface{}{
+								"size": map[string]interface{}{"type": "integer"},
+								"port": map[string]interface{}{
+									"x-kubernetes-int-or-string": true,
+									"anyOf":                      []interface{}{map[string]interface{}{"type": "integer"}, map[string]interface{}{"type": "string"}},
+								},
+							},
+						},
+					},
+				}},
+			},
+			map[string]interface{}{"name": "v1alpha1", "served": false},
+		},
+	},
+}}
+
+// generate writes the schemas for the fake discovery client and the Widget CRD, and compiles each generated package
+func generate(t *testing.T) map[schema.GroupVersion]*cue.Instance {
+	t.Helper()
+	g := schemas.NewGenerator()
+	if err := g.AddCRD(widgetCRD); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddDiscovery(discovery); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	written, err := g.Write(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in the lexical order Write returns them in
+	gvs := []schema.GroupVersion{{Group: "example.com", Version: "v1"}, {Version: "v1"}}
+	var want []string
+	for _, gv := range gvs {
+		want = append(want, filepath.Join(root, schemas.GenDir, schemas.PackagePath(gv), schemas.FileName))
+	}
+	if strings.Join(written, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got files %v, want %v", written, want)
+	}
+
+	instances := map[schema.GroupVersion]*cue.Instance{}
+	for i, gv := range gvs {
+		b, err := ioutil.ReadFile(want[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "// Code generated") {
+			t.Errorf("%s has no generated header", want[i])
+		}
+		r := &cue.Runtime{}
+		instance, err := r.Compile(want[i], b)
+		if err != nil {
+			t.Fatalf("%s doesn't compile: %v\n%s", want[i], err, b)
+		}
+		if err := instance.Value().Validate(); err != nil {
+			t.Fatalf("%s isn't valid: %v\n%s", want[i], err, b)
+		}
+		instances[gv] = instance
+	}
+	return instances
+}
+
+func TestGenerate(t *testing.T) {
+	instances := generate(t)
+	core, example := instances[schema.GroupVersion{Version: "v1"}], instances[schema.GroupVersion{Group: "example.com", Version: "v1"}]
+
+	tests := []struct {
+		name       string
+		instance   *cue.Instance
+		definition string
+		src        string
+		valid      bool
+	}{
+		{
+			name:       "open definition",
+			instance:   core,
+			definition: "#ConfigMap",
+			src:        `{apiVersion: "v1", kind: "ConfigMap", metadata: name: "config", data: key: "value"}`,
+			valid:      true,
+		},
+		{
+			name:       "subresources are skipped",
+			instance:   core,
+			definition: "#Pod",
+			src:        `{apiVersion: "v1", kind: "Pod", metadata: name: "pod"}`,
+			valid:      true,
+		},
+		{
+			name:       "wrong kind",
+			instance:   core,
+			definition: "#ConfigMap",
+			src:        `{apiVersion: "v1", kind: "Secret"}`,
+		},
+		{
+			name:       "crd schema",
+			instance:   example,
+			definition: "#Widget",
+			src:        `{apiVersion: "example.com/v1", kind: "Widget", metadata: name: "widget", spec: {size: 3, port: "http"}}`,
+			valid:      true,
+		},
+		{
+			name:       "crd int-or-string",
+			instance:   example,
+			definition: "#Widget",
+			src:        `{apiVersion: "example.com/v1", kind: "Widget", spec: {size: 3, port: 8080}}`,
+			valid:      true,
+		},
+		{
+			name:       "crd type mismatch",
+			instance:   example,
+			definition: "#Widget",
+			src:        `{apiVersion: "example.com/v1", kind: "Widget", spec: size: "large"}`,
+		},
+		{
+			name:       "crd wrong apiVersion",
+			instance:   example,
+			definition: "#Widget",
+			src:        `{apiVersion: "example.com/v2", kind: "Widget", spec: size: 3}`,
+		},
+		{
+			name:       "discovered kind without crd",
+			instance:   example,
+			definition: "#Gadget",
+			src:        `{apiVersion: "example.com/v1", kind: "Gadget", spec: anything: true}`,
+			valid:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := tt.instance.LookupDef(tt.definition)
+			if !def.Exists() {
+				t.Fatalf("%s is not defined", tt.definition)
+			}
+			r := &cue.Runtime{}
+			obj, err := r.Compile("object.cue", tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = def.Unify(obj.Value()).Validate(cue.Concrete(true))
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPackagePath(t *testing.T) {
+	tests := []struct {
+		gv   schema.GroupVersion
+		want string
+	}{
+		{gv: schema.GroupVersion{Version: "v1"}, want: "k8s.io/api/core/v1"},
+		{gv: schema.GroupVersion{Group: "apps", Version: "v1"}, want: "k8s.io/api/apps/v1"},
+		{gv: schema.GroupVersion{Group: "rbac.authorization.k8s.io", Version: "v1"}, want: "rbac.authorization.k8s.io/v1"},
+	}
+	for _, tt := range tests {
+		if got := schemas.PackagePath(tt.gv); got != tt.want {
+			t.Errorf("PackagePath(%s) = %q, want %q", tt.gv, got, tt.want)
+		}
+	}
+}
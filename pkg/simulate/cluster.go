@@ -0,0 +1,460 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+// Package simulate provides an in-memory cluster that objects can be ensured in without writing to a real cluster.
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/testing"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/identity"
+)
+
+// Cluster is an in-memory cluster. Like an API server, it generates names, resource versions and uids for objects,
+// and filters lists and watches by label and field selectors. Server-side apply patches are approximated by merge
+// patches, and strategic merge patches are only accepted for kinds with Go types.
+//
+// If a Cluster has a source, the objects of a resource in a namespace are copied from the source the first time they
+// are used, so that reads see the objects in the source cluster and writes only change the copies.
+type Cluster struct {
+	*fake.FakeDynamicClient
+	tracker testing.ObjectTracker
+	source  dynamic.Interface
+
+	// version is the last generated resource version
+	version int64
+
+	seeded   map[identity.NamespacedGroupVersionResource]struct{}
+	mutators map[schema.GroupKind][]Mutator
+
+	// generated counts the names generated for each generateName, for objects that weren't created for a field
+	generated map[string]int
+	sync.Mutex
+}
+
+// Mutator changes an object that is created or updated, like an API server or a controller would, i.e. to allocate a
+// Service's clusterIP or to set a Deployment's status
+type Mutator func(u *unstructured.Unstructured)
+
+var _ dynamic.Interface = &Cluster{}
+
+// NewCluster returns a cluster that contains objects, and copies other objects from source as they are used. source
+// may be nil, in which case the cluster only contains objects.
+func NewCluster(source dynamic.Interface, objects ...runtime.Object) (*Cluster, error) {
+	scheme := runtime.NewScheme()
+	c := &Cluster{
+		FakeDynamicClient: fake.NewSimpleDynamicClient(scheme),
+		source:            source,
+		seeded:            map[identity.NamespacedGroupVersionResource]struct{}{},
+		mutators:          map[schema.GroupKind][]Mutator{},
+		generated:         map[string]int{},
+	}
+	c.tracker = testing.NewObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder())
+	for _, o := range objects {
+		if err := c.tracker.Add(o); err != nil {
+			return nil, err
+		}
+	}
+	c.PrependReactor("*", "*", c.react)
+	c.PrependWatchReactor("*", c.watch)
+	return c, nil
+}
+
+// Resource returns the client for gvr. Objects are copied from the source before each request, if they haven't been
+// already.
+func (c *Cluster) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &namespaceableResource{
+		resource: resource{ResourceInterface: c.FakeDynamicClient.Resource(gvr), seed: func() error { return c.seed(gvr, "") }},
+		client:   c.FakeDynamicClient.Resource(gvr),
+		cluster:  c,
+		gvr:      gvr,
+	}
+}
+
+// Create adds obj to the cluster as the resource gvr, without generating any of its fields
+func (c *Cluster) Create(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	return c.tracker.Create(gvr, obj, obj.GetNamespace())
+}
+
+// Mutate registers a mutator for objects of kind gk. Mutators run in the order they were registered.
+func (c *Cluster) Mutate(gk schema.GroupKind, m Mutator) {
+	c.Lock()
+	defer c.Unlock()
+	c.mutators[gk] = append(c.mutators[gk], m)
+}
+
+// mutate runs the mutators for u
+func (c *Cluster) mutate(u *unstructured.Unstructured) {
+	c.Lock()
+	mutators := c.mutators[u.GroupVersionKind().GroupKind()]
+	c.Unlock()
+	for _, m := range mutators {
+		m(u)
+	}
+}
+
+// seed copies the objects of gvr in namespace from the source, unless they have been copied before
+func (c *Cluster) seed(gvr schema.GroupVersionResource, namespace string) error {
+	if c.source == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	ngvr := identity.NamespacedGroupVersionResource{GroupVersionResource: gvr, Namespace: namespace}
+	all := identity.NamespacedGroupVersionResource{GroupVersionResource: gvr}
+	if _, ok := c.seeded[ngvr]; ok {
+		return nil
+	}
+	if _, ok := c.seeded[all]; ok {
+		return nil
+	}
+
+	list, err := c.source.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		item := &list.Items[i]
+		if err := c.tracker.Create(gvr, item, item.GetNamespace()); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	c.seeded[ngvr] = struct{}{}
+	return nil
+}
+
+// nextVersion returns a new resource version
+func (c *Cluster) nextVersion() int64 {
+	return atomic.AddInt64(&c.version, 1)
+}
+
+// react handles requests like an API server would, and leaves requests it doesn't handle to the object tracker
+func (c *Cluster) react(action testing.Action) (bool, runtime.Object, error) {
+	gvr := action.GetResource()
+	ns := action.GetNamespace()
+
+	switch a := action.(type) {
+	case testing.CreateActionImpl:
+		u, ok := a.GetObject().(*unstructured.Unstructured)
+		if !ok || a.GetSubresource() != "" {
+			break
+		}
+		u = u.DeepCopy()
+		c.created(gvr, u)
+		if err := c.tracker.Create(gvr, u, ns); err != nil {
+			return true, nil, err
+		}
+		return true, u.DeepCopy(), nil
+
+	case testing.UpdateActionImpl:
+		u, ok := a.GetObject().(*unstructured.Unstructured)
+		if !ok {
+			break
+		}
+		u = u.DeepCopy()
+		existing, err := c.tracker.Get(gvr, ns, u.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		u, err = c.update(gvr, ns, u, existing.(*unstructured.Unstructured))
+		return true, u, err
+
+	case testing.PatchActionImpl:
+		u, err := c.patch(gvr, ns, a.GetName(), a.GetPatchType(), a.GetPatch())
+		return true, u, err
+
+	case testing.ListActionImpl:
+		obj, err := c.tracker.List(gvr, a.GetKind(), ns)
+		if err != nil {
+			return true, nil, err
+		}
+		return true, obj, filterList(obj, a.GetListRestrictions().Labels, a.GetListRestrictions().Fields)
+	}
+	return testing.ObjectReaction(c.tracker)(action)
+}
+
+// patch applies a patch to the object name, or creates it from an apply patch if it doesn't exist
+func (c *Cluster) patch(gvr schema.GroupVersionResource, ns, name string, pt types.PatchType, data []byte) (*unstructured.Unstructured, error) {
+	obj, err := c.tracker.Get(gvr, ns, name)
+	if errors.IsNotFound(err) && pt == types.ApplyPatchType {
+		u := &unstructured.Unstructured{}
+		if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), len(data)).Decode(&u.Object); err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+		u.SetName(name)
+		u.SetNamespace(ns)
+		c.created(gvr, u)
+		if err := c.tracker.Create(gvr, u, ns); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	existing := obj.(*unstructured.Unstructured)
+
+	current, err := json.Marshal(existing.Object)
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	switch pt {
+	case types.JSONPatchType:
+		patch, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+		patched, err = patch.Apply(current)
+		if err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+	case types.MergePatchType, types.ApplyPatchType:
+		if data, err = yaml.ToJSON(data); err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+		patched, err = jsonpatch.MergePatch(current, data)
+		if err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+	case types.StrategicMergePatchType:
+		// like an API server, strategic merge patches are only accepted for kinds with Go types
+		obj, err := scheme.Scheme.New(existing.GroupVersionKind())
+		if err != nil {
+			return nil, errors.NewGenericServerResponse(http.StatusUnsupportedMediaType, "patch", gvr.GroupResource(), name, fmt.Sprintf("patch type %s is not supported for %s", pt, existing.GroupVersionKind()), 0, false)
+		}
+		patched, err = strategicpatch.StrategicMergePatch(current, data, obj)
+		if err != nil {
+			return nil, errors.NewBadRequest(err.Error())
+		}
+	default:
+		return nil, errors.NewBadRequest(fmt.Sprintf("patch type %s is not supported", pt))
+	}
+
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal(patched, &u.Object); err != nil {
+		return nil, err
+	}
+	return c.update(gvr, ns, u, existing)
+}
+
+// update replaces existing with u, unless they are the same except for generated fields
+func (c *Cluster) update(gvr schema.GroupVersionResource, ns string, u, existing *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	u.SetResourceVersion(existing.GetResourceVersion())
+	u.SetGeneration(existing.GetGeneration())
+	u.SetUID(existing.GetUID())
+	u.SetCreationTimestamp(existing.GetCreationTimestamp())
+	c.mutate(u)
+	if equality.Semantic.DeepEqual(u.Object, existing.Object) {
+		return existing, nil
+	}
+	u.SetResourceVersion(fmt.Sprint(c.nextVersion()))
+	if !equality.Semantic.DeepEqual(withoutMetadata(u), withoutMetadata(existing)) {
+		u.SetGeneration(existing.GetGeneration() + 1)
+	}
+	if err := c.tracker.Update(gvr, u, ns); err != nil {
+		return nil, err
+	}
+	return u.DeepCopy(), nil
+}
+
+// created sets the fields of a new object that are generated by an API server
+func (c *Cluster) created(gvr schema.GroupVersionResource, u *unstructured.Unstructured) {
+	version := c.nextVersion()
+	if u.GetName() == "" && u.GetGenerateName() != "" {
+		u.SetName(c.generateName(gvr, u))
+	}
+	u.SetUID(types.UID(fmt.Sprintf("simulated-%d", version)))
+	u.SetResourceVersion(fmt.Sprint(version))
+	u.SetGeneration(1)
+	u.SetCreationTimestamp(metav1.Now())
+	c.mutate(u)
+}
+
+// generateName returns an unused name for u, from its generateName. Objects created for a field get a suffix
+// derived from the field's path, so that generated names don't depend on the order objects are created in. Other
+// objects are numbered in the order they are created.
+func (c *Cluster) generateName(gvr schema.GroupVersionResource, u *unstructured.Unstructured) string {
+	c.Lock()
+	defer c.Unlock()
+	namespace, base := u.GetNamespace(), u.GetGenerateName()
+	path, fromField := u.GetAnnotations()[ensure.PathAnnotation]
+	key := fmt.Sprintf("%s/%s/%s", gvr, namespace, base)
+	for attempt := 0; ; attempt++ {
+		var name string
+		if fromField {
+			h := fnv.New32a()
+			fmt.Fprintf(h, "%s\x00%d", path, attempt)
+			name = fmt.Sprintf("%s%05x", base, h.Sum32()&0xfffff)
+		} else {
+			c.generated[key]++
+			name = fmt.Sprintf("%s%05d", base, c.generated[key])
+		}
+		if _, err := c.tracker.Get(gvr, namespace, name); errors.IsNotFound(err) {
+			return name
+		}
+	}
+}
+
+// withoutMetadata returns the fields of u other than metadata, which are the fields that change its generation
+func withoutMetadata(u *unstructured.Unstructured) map[string]interface{} {
+	fields := make(map[string]interface{}, len(u.Object))
+	for k, v := range u.Object {
+		if k != "metadata" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// watch returns a watch of the tracker that only sends events for objects matching the watch's selectors
+func (c *Cluster) watch(action testing.Action) (bool, watch.Interface, error) {
+	w, err := c.tracker.Watch(action.GetResource(), action.GetNamespace())
+	if err != nil {
+		return true, nil, err
+	}
+	a, ok := action.(testing.WatchActionImpl)
+	if !ok {
+		return true, w, nil
+	}
+	restrictions := a.GetWatchRestrictions()
+	return true, watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+		return e, matches(e.Object, restrictions.Labels, restrictions.Fields)
+	}), nil
+}
+
+// filterList removes the items of list that don't match the selectors
+func filterList(list runtime.Object, l labels.Selector, f fields.Selector) error {
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	var filtered []runtime.Object
+	for _, item := range items {
+		if matches(item, l, f) {
+			filtered = append(filtered, item)
+		}
+	}
+	return meta.SetList(list, filtered)
+}
+
+// matches returns true if obj matches the label selector, and its name and namespace match the field selector
+func matches(obj runtime.Object, l labels.Selector, f fields.Selector) bool {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	if l != nil && !l.Matches(labels.Set(m.GetLabels())) {
+		return false
+	}
+	return f == nil || f.Matches(fields.Set{"metadata.name": m.GetName(), "metadata.namespace": m.GetNamespace()})
+}
+
+type namespaceableResource struct {
+	resource
+	client  dynamic.NamespaceableResourceInterface
+	cluster *Cluster
+	gvr     schema.GroupVersionResource
+}
+
+func (r *namespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &resource{ResourceInterface: r.client.Namespace(namespace), seed: func() error { return r.cluster.seed(r.gvr, namespace) }}
+}
+
+// resource seeds the cluster before each request
+type resource struct {
+	dynamic.ResourceInterface
+	seed func() error
+}
+
+func (r *resource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Create(ctx, obj, options, subresources...)
+}
+
+func (r *resource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Update(ctx, obj, options, subresources...)
+}
+
+func (r *resource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.UpdateStatus(ctx, obj, options)
+}
+
+func (r *resource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	if err := r.seed(); err != nil {
+		return err
+	}
+	return r.ResourceInterface.Delete(ctx, name, options, subresources...)
+}
+
+func (r *resource) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	if err := r.seed(); err != nil {
+		return err
+	}
+	return r.ResourceInterface.DeleteCollection(ctx, options, listOptions)
+}
+
+func (r *resource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Get(ctx, name, options, subresources...)
+}
+
+func (r *resource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.List(ctx, opts)
+}
+
+func (r *resource) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Watch(ctx, opts)
+}
+
+func (r *resource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if err := r.seed(); err != nil {
+		return nil, err
+	}
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier:  Apache-2.0
+// SPDX-FileCopyrightText: 2020 Evan Cordell
+
+package simulate_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
+	"github.com/cuebernetes/cuebectl/pkg/simulate"
+)
+
+var clusterRoles = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+
+func clusterRole(path string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRole",
+		"metadata":   map[string]interface{}{"generateName": "test-"},
+	}}
+	if path != "" {
+		u.SetAnnotations(map[string]string{ensure.PathAnnotation: path})
+	}
+	return u
+}
+
+// create creates an object for each path, in order, and returns the generated names by path
+func create(t *testing.T, paths ...string) map[string]string {
+	t.Helper()
+	c, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{}
+	for _, path := range paths {
+		u, err := c.Resource(clusterRoles).Create(context.Background(), clusterRole(path), metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(u.GetName(), "test-") || u.GetName() == "test-" {
+			t.Errorf("got name %q, want a name generated from test-", u.GetName())
+		}
+		names[path] = u.GetName()
+	}
+	return names
+}
+
+func TestGenerateNameDoesNotDependOnOrder(t *testing.T) {
+	forward := create(t, "first", "second")
+	backward := create(t, "second", "first")
+	if forward["first"] == forward["second"] {
+		t.Fatalf("fields were given the same name %s", forward["first"])
+	}
+	for _, path := range []string{"first", "second"} {
+		if forward[path] != backward[path] {
+			t.Errorf("%s: got %s when created first, and %s when created second", path, forward[path], backward[path])
+		}
+	}
+}
+
+func TestGenerateNameWithoutPath(t *testing.T) {
+	c, err := simulate.NewCluster(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < 2; i++ {
+		u, err := c.Resource(clusterRoles).Create(context.Background(), clusterRole(""), metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, u.GetName())
+	}
+	if names[0] != "test-00001" || names[1] != "test-00002" {
+		t.Errorf("got names %v, want objects without a path to be numbered", names)
+	}
+}
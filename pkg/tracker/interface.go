@@ -4,11 +4,15 @@
 package tracker
 
 import (
+	"github.com/cuebernetes/cuebectl/pkg/ensure"
 	"github.com/cuebernetes/cuebectl/pkg/identity"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type Interface interface {
-	Sync(obj *unstructured.Unstructured, path ...string) (string, *identity.Locator, error)
+	Sync(obj *unstructured.Unstructured, options ensure.Options, path ...string) (string, *identity.Locator, error)
+	Track(locator *identity.Locator) bool
+	Delete(path ...string) error
+	Untrack(path ...string)
 	Locators() (locators []*identity.Locator)
 }
@@ -30,9 +30,12 @@ func NewLocationTracker(ensurer ensure.Interface) *LocationTracker {
 
 // Sync attempts to create an unstructured object identified by []path in instance.
 // if successful, it returns a locator that can be used to lookup the object in the cluster later.
-func (a *LocationTracker) Sync(obj *unstructured.Unstructured, path ...string) (string, *identity.Locator, error) {
+// an existing object that is adopted according to options is tracked the same as a created one.
+func (a *LocationTracker) Sync(obj *unstructured.Unstructured, options ensure.Options, path ...string) (string, *identity.Locator, error) {
 	rv := obj.GetResourceVersion()
-	_, locator, err := a.ensurer.EnsureUnstructured(obj)
+	options.Path = path
+	_, options.Tracked = a.locators.Load(strings.Join(path, "."))
+	_, locator, err := a.ensurer.EnsureUnstructured(obj, options)
 	if err != nil {
 		return "", nil, err
 	}
@@ -44,6 +47,43 @@ func (a *LocationTracker) Sync(obj *unstructured.Unstructured, path ...string) (
 	return rv, &locator, nil
 }
 
+// Track records a locator for an object that is not synced by the tracker, such as a read-only reference.
+// It returns true if the locator is new or identifies a different object than before.
+func (a *LocationTracker) Track(locator *identity.Locator) bool {
+	previous, loaded := a.locators.Load(strings.Join(locator.Path, "."))
+	if loaded {
+		p := previous.(*identity.Locator)
+		if p.NamespacedGroupVersionResource == locator.NamespacedGroupVersionResource && p.Name == locator.Name &&
+			p.List == locator.List && p.Selector == locator.Selector && p.Context == locator.Context {
+			return false
+		}
+	}
+	a.locators.Store(strings.Join(locator.Path, "."), locator)
+	return true
+}
+
+// Delete stops tracking the object identified by path, and deletes it if it was synced by the tracker.
+func (a *LocationTracker) Delete(path ...string) error {
+	key := strings.Join(path, ".")
+	value, ok := a.locators.Load(key)
+	if !ok {
+		return nil
+	}
+	locator := value.(*identity.Locator)
+	if !locator.ReadOnly {
+		if err := a.ensurer.DeleteUnstructured(*locator); err != nil {
+			return err
+		}
+	}
+	a.locators.Delete(key)
+	return nil
+}
+
+// Untrack stops tracking the object identified by path, leaving it in the cluster
+func (a *LocationTracker) Untrack(path ...string) {
+	a.locators.Delete(strings.Join(path, "."))
+}
+
 // Locators returns the list of locators for concrete values
 func (a *LocationTracker) Locators() (locators []*identity.Locator) {
 	locators = make([]*identity.Locator, 0)
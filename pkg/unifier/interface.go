@@ -7,12 +7,16 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
 	"github.com/cuebernetes/cuebectl/pkg/identity"
 )
 
 type Interface interface {
-	Fill(queue workqueue.RateLimitingInterface) (total int, err error)
+	Fill(fromCluster map[*identity.Locator]*unstructured.Unstructured, queue workqueue.RateLimitingInterface) (total int, err error)
 	Lookup(fromCluster map[*identity.Locator]*unstructured.Unstructured, path ...string) (*unstructured.Unstructured, error)
+	Reference(fromCluster map[*identity.Locator]*unstructured.Unstructured, path ...string) (*identity.Reference, error)
+	Attributes(path ...string) attributes.Attributes
+	Source(path ...string) string
 }
 
 
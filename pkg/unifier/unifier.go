@@ -4,29 +4,66 @@
 package unifier
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"cuelang.org/go/cue"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
 
+	"github.com/cuebernetes/cuebectl/pkg/attributes"
 	"github.com/cuebernetes/cuebectl/pkg/cache"
+	"github.com/cuebernetes/cuebectl/pkg/cuelock"
 	"github.com/cuebernetes/cuebectl/pkg/identity"
+	"github.com/cuebernetes/cuebectl/pkg/metrics"
 )
 
+// ErrNotExist is returned by Lookup when the path doesn't exist in the unified instance, which happens when a label
+// generated from cluster state is no longer generated.
+var ErrNotExist = errors.New("path does not exist in instance")
+
+// NotConcreteError is returned when the value at a path can't be synced yet because it isn't concrete
+type NotConcreteError struct {
+	Path    string
+	Message string
+}
+
+func (e *NotConcreteError) Error() string {
+	return fmt.Sprintf("%s not yet concrete: %s", e.Path, e.Message)
+}
+
 // ClusterUnifier takes an initial cue.Instance and can return a new cue.Instance where initial has been unified
 // with the current state of the cluster.
+//
+// Unified instances are cached: each object from the cluster is filled on top of the instance produced by the
+// previous fill, so when an object changes only the fills after it need to be redone. Objects that change are moved
+// to the end of the sequence, so that objects that change often are cheap to refill.
 type ClusterUnifier struct {
 	runtime     *cue.Runtime
 	instance    *cue.Instance
 	informerSet cache.Interface
 
-	// protects access to the build.Instance being unified
+	// fills is the sequence of objects filled into instance, and the instance after each fill
+	fills []fill
+
+	// protects fills, and serializes fills with lookups. lookups on unified instances can run concurrently.
 	sync.RWMutex
 }
 
+// fill records an object that has been filled into the instance
+type fill struct {
+	key      string
+	version  string
+	instance *cue.Instance
+}
+
 func NewClusterUnifier(runtime *cue.Runtime, instance *cue.Instance, informerSet cache.Interface) *ClusterUnifier {
 	return &ClusterUnifier{
 		runtime:     runtime,
@@ -36,22 +73,116 @@ func NewClusterUnifier(runtime *cue.Runtime, instance *cue.Instance, informerSet
 }
 
 // unify takes the initial instance and updates the unified representation with current cluster state.
-// the cluster state is constructed from the informer cache
-func (u *ClusterUnifier) unify(fromCluster map[*identity.Locator]*unstructured.Unstructured) (instance *cue.Instance, err error) {
+// the cluster state is constructed from the informer cache. Only objects that are new, or whose resourceVersion has
+// changed since the last unification, are filled.
+func (u *ClusterUnifier) unify(fromCluster map[*identity.Locator]*unstructured.Unstructured) (*cue.Instance, error) {
+	defer func(start time.Time) {
+		metrics.UnificationDuration.Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	type object struct {
+		locator *identity.Locator
+		obj     *unstructured.Unstructured
+		version string
+	}
+	current := make(map[string]object, len(fromCluster))
+	for l, o := range fromCluster {
+		key := strings.Join(l.Path, "/")
+		current[key] = object{locator: l, obj: o, version: version(o)}
+	}
+
 	u.Lock()
 	defer u.Unlock()
-	i := *u.instance
-	instance = &i
-	for l, o := range fromCluster {
-		if instance, err = instance.Fill(o, l.Path...); err != nil {
-			return
+
+	// keep the longest prefix of fills that is unchanged
+	keep := 0
+	for ; keep < len(u.fills); keep++ {
+		f := u.fills[keep]
+		if c, ok := current[f.key]; !ok || c.version != f.version {
+			break
 		}
 	}
-	return
+
+	// refill the unchanged objects after the first change, then changed objects, then new ones
+	var unchanged, changed, added []string
+	seen := map[string]struct{}{}
+	for i, f := range u.fills {
+		seen[f.key] = struct{}{}
+		if i < keep {
+			continue
+		}
+		c, ok := current[f.key]
+		switch {
+		case !ok:
+		case c.version == f.version:
+			unchanged = append(unchanged, f.key)
+		default:
+			changed = append(changed, f.key)
+		}
+	}
+	for key := range current {
+		if _, ok := seen[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+
+	fills := u.fills[:keep:keep]
+	instance := u.instance
+	if keep > 0 {
+		instance = fills[keep-1].instance
+	}
+	for _, key := range append(append(unchanged, changed...), added...) {
+		c := current[key]
+		cuelock.Lock()
+		next, err := instance.Fill(c.obj, c.locator.Path...)
+		cuelock.Unlock()
+		if err != nil {
+			u.fills = fills
+			return nil, err
+		}
+		instance = next
+		fills = append(fills, fill{key: key, version: c.version, instance: instance})
+	}
+	if refilled := len(fills) - keep; refilled > 0 {
+		klog.V(4).Infof("refilled %d of %d objects", refilled, len(fills))
+	}
+	u.fills = fills
+	return instance, nil
+}
+
+// version identifies the state of an object, or of the items of a list
+func version(u *unstructured.Unstructured) string {
+	if rv := u.GetResourceVersion(); rv != "" {
+		return rv
+	}
+	items, ok := u.Object["items"].([]interface{})
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	for _, i := range items {
+		item, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		o := unstructured.Unstructured{Object: item}
+		fmt.Fprintf(&b, "%s/%s@%s,", o.GetNamespace(), o.GetName(), o.GetResourceVersion())
+	}
+	return b.String()
 }
 
-func (u *ClusterUnifier) Fill(queue workqueue.RateLimitingInterface) (total int, err error) {
-	instance := *u.instance
+// Fill adds every top-level label of the instance, unified with the cluster state, to the queue. Labels may be
+// generated from cluster state, e.g. by comprehensions over lists, so the total can change as the state changes.
+func (u *ClusterUnifier) Fill(fromCluster map[*identity.Locator]*unstructured.Unstructured, queue workqueue.RateLimitingInterface) (total int, err error) {
+	instance, err := u.unify(fromCluster)
+	if err != nil {
+		return
+	}
+	u.RLock()
+	defer u.RUnlock()
+	cuelock.RLock()
+	defer cuelock.RUnlock()
 	itr, err := instance.Value().Fields()
 	if err != nil {
 		return
@@ -73,11 +204,16 @@ func (u *ClusterUnifier) Lookup(fromCluster map[*identity.Locator]*unstructured.
 
 	u.RLock()
 	defer u.RUnlock()
+	cuelock.RLock()
+	defer cuelock.RUnlock()
 	cueValue := instance.Lookup(path...)
+	if !cueValue.Exists() {
+		return nil, ErrNotExist
+	}
 	if err := cueValue.Validate(cue.Concrete(true)); err != nil {
 		// note: err is not safe to return over the error chan because it holds references to the instance internals.
 		// this takes the error string only and returns it
-		return nil, fmt.Errorf("%s not yet concrete: %s", strings.Join(path, "/"), err.Error())
+		return nil, &NotConcreteError{Path: strings.Join(path, "/"), Message: err.Error()}
 	}
 
 	obj := &unstructured.Unstructured{}
@@ -88,3 +224,78 @@ func (u *ClusterUnifier) Lookup(fromCluster map[*identity.Locator]*unstructured.
 
 	return obj, nil
 }
+
+// Reference first unifies the instance with the cluster state, and then reads the identity of a referenced object
+// (or list of objects) at path. Only apiVersion, kind, and metadata need to be concrete. The name is only required for
+// references without a selector; the objects of other fields may be created with generateName.
+func (u *ClusterUnifier) Reference(fromCluster map[*identity.Locator]*unstructured.Unstructured, path ...string) (*identity.Reference, error) {
+	instance, err := u.unify(fromCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	u.RLock()
+	defer u.RUnlock()
+	cuelock.RLock()
+	defer cuelock.RUnlock()
+	cueValue := instance.Lookup(path...)
+
+	field := func(required bool, fieldPath ...string) (string, error) {
+		v := cueValue.Lookup(fieldPath...)
+		if !v.Exists() && !required {
+			return "", nil
+		}
+		s, err := v.String()
+		if err != nil {
+			return "", &NotConcreteError{Path: strings.Join(path, "/") + "/" + strings.Join(fieldPath, "/"), Message: err.Error()}
+		}
+		return s, nil
+	}
+
+	ref := &identity.Reference{}
+	apiVersion, err := field(true, "apiVersion")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := field(true, "kind")
+	if err != nil {
+		return nil, err
+	}
+	ref.GroupVersionKind = schema.FromAPIVersionAndKind(apiVersion, kind)
+	if ref.Namespace, err = field(false, "metadata", "namespace"); err != nil {
+		return nil, err
+	}
+	attrs := u.attributes(path...)
+	ref.Selector, _ = attrs.Get(attributes.Selector)
+	if ref.Name, err = field(attrs.Flag(attributes.Ref) && ref.Selector == "", "metadata", "name"); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Attributes returns the @cuebectl attributes of the field at path in the initial instance
+func (u *ClusterUnifier) Attributes(path ...string) attributes.Attributes {
+	cuelock.RLock()
+	defer cuelock.RUnlock()
+	return u.attributes(path...)
+}
+
+// attributes returns the attributes of the field at path. cuelock must be held.
+func (u *ClusterUnifier) attributes(path ...string) attributes.Attributes {
+	return attributes.Parse(u.instance.Lookup(path...))
+}
+
+// Source returns the file:line position of the field at path in the initial instance, relative to the instance dir
+func (u *ClusterUnifier) Source(path ...string) string {
+	cuelock.RLock()
+	pos := u.instance.Lookup(path...).Pos()
+	cuelock.RUnlock()
+	if !pos.IsValid() {
+		return ""
+	}
+	filename := pos.Filename()
+	if rel, err := filepath.Rel(u.instance.Dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		filename = rel
+	}
+	return fmt.Sprintf("%s:%d", filename, pos.Line())
+}